@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// BlockTag 指定查询状态时使用的区块，可以是区块号、区块哈希值或者 latest 等标签
+// 零值和 LatestBlock 一样代表最新的区块
+type BlockTag struct {
+	tag              string
+	number           *big.Int
+	hash             *common.Hash
+	requireCanonical bool
+}
+
+var (
+	LatestBlock    = BlockTag{tag: "latest"}    // 最新的区块
+	PendingBlock   = BlockTag{tag: "pending"}   // 包含交易池中交易的 pending 状态
+	SafeBlock      = BlockTag{tag: "safe"}      // 共识层认为安全的区块，合并之后的节点才支持
+	FinalizedBlock = BlockTag{tag: "finalized"} // 已经最终确定的区块，合并之后的节点才支持
+	EarliestBlock  = BlockTag{tag: "earliest"}  // 创世区块
+)
+
+// BlockAtNumber 使用区块号指定区块，例如和区块遍历器保存到数据库中的区块对齐
+func BlockAtNumber(number *big.Int) BlockTag {
+	return BlockTag{number: new(big.Int).Set(number)}
+}
+
+// BlockAtHash 使用区块哈希值指定区块（EIP-1898）
+// requireCanonical 为 true 时区块已经不在主链上的话节点会返回错误，而不是返回分叉区块上的状态
+func BlockAtHash(hash string, requireCanonical bool) BlockTag {
+	blockHash := common.HexToHash(hash)
+	return BlockTag{hash: &blockHash, requireCanonical: requireCanonical}
+}
+
+// String 返回区块号的十六进制、区块哈希值或者标签
+func (b BlockTag) String() string {
+	switch {
+	case b.hash != nil:
+		return b.hash.Hex()
+	case b.number != nil:
+		return hexutil.EncodeBig(b.number)
+	case b.tag != "":
+		return b.tag
+	default:
+		return "latest"
+	}
+}
+
+// MarshalJSON 编码为 rpc 的区块参数，区块哈希值按照 EIP-1898 编码为对象
+func (b BlockTag) MarshalJSON() ([]byte, error) {
+	if b.hash != nil {
+		return json.Marshal(struct {
+			BlockHash        common.Hash `json:"blockHash"`
+			RequireCanonical bool        `json:"requireCanonical"`
+		}{*b.hash, b.requireCanonical})
+	}
+	if b.number != nil && b.number.Sign() < 0 {
+		return nil, errors.New("negative block number")
+	}
+	return json.Marshal(b.String())
+}
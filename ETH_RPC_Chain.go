@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ErrChainIdMismatch 代表配置的 chain id 和节点的 chain id 不一致，此时拒绝签名交易
+var ErrChainIdMismatch = errors.New("chain id mismatch")
+
+// 缓存的 chain id，ETHRPCRequester 按值复制后仍然共用同一个缓存
+type chainIdCache struct {
+	lock     sync.Mutex
+	expected *big.Int // 配置的 chain id，为空时以节点为准
+	chainId  *big.Int // 从节点获取并且校验通过的 chain id
+}
+
+// GetChainId 获取节点所在链的 chain id
+func (r *ETHRPCRequester) GetChainId() (*big.Int, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetChainIdContext(ctx)
+}
+
+// GetChainIdContext 获取节点所在链的 chain id
+func (r *ETHRPCRequester) GetChainIdContext(ctx context.Context) (*big.Int, error) {
+	result := hexutil.Big{}
+	if err := r.client.CallContext(ctx, &result, "eth_chainId"); err != nil {
+		return nil, fmt.Errorf("获取 chain id 失败 %s", err.Error())
+	}
+	return result.ToInt(), nil
+}
+
+// SetChainId 配置交易所在链的 chain id，节点的 chain id 和它不一致时拒绝签名交易，
+// 节点池中 chain id 不一致的节点也会被标记为不健康
+func (r *ETHRPCRequester) SetChainId(chainId *big.Int) {
+	r.chain.lock.Lock()
+	r.chain.expected = new(big.Int).Set(chainId)
+	r.chain.chainId = nil // 下一次使用时重新校验
+	r.chain.lock.Unlock()
+	r.client.SetChainId(chainId)
+}
+
+// ChainId 返回签名交易时使用的 chain id，第一次调用时从节点获取并缓存
+func (r *ETHRPCRequester) ChainId() (*big.Int, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.ChainIdContext(ctx)
+}
+
+// ChainIdContext 返回签名交易时使用的 chain id，第一次调用时从节点获取并缓存
+// 配置了 chain id 并且和节点不一致时返回的错误包含 ErrChainIdMismatch
+// 缓存之后节点池切换节点不会重新获取，所以没有配置时以第一次获取的为准，由节点池的健康检查排除其它链的节点
+func (r *ETHRPCRequester) ChainIdContext(ctx context.Context) (*big.Int, error) {
+	r.chain.lock.Lock()
+	defer r.chain.lock.Unlock()
+	if r.chain.chainId != nil {
+		return new(big.Int).Set(r.chain.chainId), nil
+	}
+	chainId, err := r.GetChainIdContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.chain.expected != nil && r.chain.expected.Cmp(chainId) != 0 {
+		return nil, fmt.Errorf("%w: 配置的是 %s，节点的是 %s", ErrChainIdMismatch, r.chain.expected.String(), chainId.String())
+	}
+	r.chain.chainId = chainId
+	if r.chain.expected == nil {
+		r.client.SetChainId(chainId)
+	}
+	return new(big.Int).Set(chainId), nil
+}
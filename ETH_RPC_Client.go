@@ -1,44 +1,170 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// ErrDialFailed 代表连接节点失败，可以使用 errors.Is 进行判断
+var ErrDialFailed = errors.New("dial eth node failed")
+
+// Transport 代表 rpc 客户端与节点之间的传输方式
+type Transport string
+
+const (
+	TransportHTTP      Transport = "http"      // http(s):// 链接
+	TransportWebSocket Transport = "websocket" // ws(s):// 链接
+	TransportIPC       Transport = "ipc"       // 本地 unix socket 路径
+)
+
 type ETHRPCClient struct {
-	NodeUrl string      // 代表节点的 url 链接
-	client  *rpc.Client // 代表 rpc 客户端句柄实例
+	NodeUrl   string      // 代表节点的 url 链接
+	transport Transport   // 当前使用的传输方式
+	endpoint  string      // 去掉协议前缀后真正用来连接的地址
+	client    *rpc.Client // 代表 rpc 客户端句柄实例
 }
 
+// 共享的 rpc 句柄，同一个节点链接的多个 ETHRPCClient 共用一个连接
+type sharedRpc struct {
+	client *rpc.Client
+	refs   int // 引用计数，为 0 时才真正关闭连接
+}
+
+var (
+	sharedRpcLock    sync.Mutex
+	sharedRpcClients = map[string]*sharedRpc{}
+)
+
 // NewETHRPCClient 代表的是新建一个 RPC 客户端
 // 参数 nodeUrl 是节点的链接，返回的是 ETHRPCClient 对象指针
-func NewETHRPCClient(nodeUrl string) *ETHRPCClient {
-	client := &ETHRPCClient{
-		NodeUrl: nodeUrl,
+// 传输方式由链接的协议决定：http(s):// 、ws(s):// 或者 unix socket 路径
+// 连接失败时返回的错误包含 ErrDialFailed
+func NewETHRPCClient(nodeUrl string) (*ETHRPCClient, error) {
+	client := newETHRPCClient(nodeUrl)
+	// 进行初始化  rpc 客户端句柄实例
+	if err := client.initRpc(); err != nil {
+		return nil, err
 	}
-	client.initRpc() // 进行初始化  rpc 客户端句柄实例
-	return client
+	return client, nil
+}
+
+// 只解析链接，不进行连接的 ETHRPCClient 实例化
+func newETHRPCClient(nodeUrl string) *ETHRPCClient {
+	transport, endpoint := parseTransport(nodeUrl)
+	return &ETHRPCClient{
+		NodeUrl:   nodeUrl,
+		transport: transport,
+		endpoint:  endpoint,
+	}
+}
+
+// 根据节点链接判断传输方式，返回传输方式和真正用来连接的地址
+func parseTransport(nodeUrl string) (Transport, string) {
+	lower := strings.ToLower(nodeUrl)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		return TransportHTTP, nodeUrl
+	case strings.HasPrefix(lower, "ws://"), strings.HasPrefix(lower, "wss://"):
+		return TransportWebSocket, nodeUrl
+	case strings.HasPrefix(lower, "unix://"):
+		return TransportIPC, nodeUrl[len("unix://"):]
+	case strings.HasPrefix(nodeUrl, "/"), strings.HasPrefix(nodeUrl, "."), strings.HasSuffix(lower, ".ipc"):
+		// 没有协议头的文件路径，例如 geth 默认的 ~/.ethereum/geth.ipc
+		return TransportIPC, nodeUrl
+	}
+	// 其余情况和以前一样按照 http 的方式处理
+	return TransportHTTP, nodeUrl
 }
 
 // 初始化 rpc 客户端句柄
-func (erc *ETHRPCClient) initRpc() {
-	// 使用 go-ethereum 库中的 rpc 来初始化
-	// DialHttp 的意思是使用 http 版本的 rpc 实现方式
-	rpcClient, err := rpc.DialHTTP(erc.NodeUrl)
+func (erc *ETHRPCClient) initRpc() error {
+	rpcClient, err := acquireRpc(erc.transport, erc.endpoint)
 	if err != nil {
-		// 初始化失败，终结程序，并将错误信息显示到控制台中
-		errInfo := fmt.Errorf("初始化 rpc client 失败%s", err.Error()).Error()
-		panic(errInfo)
+		// 初始化失败，将错误返回给调用者处理
+		return fmt.Errorf("%w: 初始化 rpc client 失败 %s", ErrDialFailed, err.Error())
 	}
 	// 初始化成功，将新实例化的 rpc 句柄赋值给 ETHRPCClient 结构体中的 client
 	erc.client = rpcClient
+	return nil
+}
+
+// 获取节点的共享 rpc 句柄，不存在则按照传输方式新建
+func acquireRpc(transport Transport, endpoint string) (*rpc.Client, error) {
+	key := string(transport) + "|" + endpoint
+	sharedRpcLock.Lock()
+	defer sharedRpcLock.Unlock()
+	if shared, ok := sharedRpcClients[key]; ok {
+		shared.refs++
+		return shared.client, nil
+	}
+	rpcClient, err := dialRpc(transport, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	sharedRpcClients[key] = &sharedRpc{client: rpcClient, refs: 1}
+	return rpcClient, nil
+}
+
+// 释放共享 rpc 句柄，引用计数归零时关闭连接
+func releaseRpc(transport Transport, endpoint string) {
+	key := string(transport) + "|" + endpoint
+	sharedRpcLock.Lock()
+	defer sharedRpcLock.Unlock()
+	shared, ok := sharedRpcClients[key]
+	if !ok {
+		return
+	}
+	shared.refs--
+	if shared.refs <= 0 {
+		shared.client.Close()
+		delete(sharedRpcClients, key)
+	}
+}
+
+// 使用 go-ethereum 库中的 rpc 按照不同的传输方式来建立连接
+func dialRpc(transport Transport, endpoint string) (*rpc.Client, error) {
+	switch transport {
+	case TransportWebSocket:
+		// websocket 会立即进行握手，origin 留空即可
+		return rpc.DialWebsocket(context.Background(), endpoint, "")
+	case TransportIPC:
+		return rpc.DialIPC(context.Background(), endpoint)
+	default:
+		// DialHttp 的意思是使用 http 版本的 rpc 实现方式
+		return rpc.DialHTTP(endpoint)
+	}
 }
 
 // GetRpc 函数是为了方便外部能够获取 client *rpc.Client，以方便进行访问
+// 重新连接失败时返回 nil
 func (erc *ETHRPCClient) GetRpc() *rpc.Client {
 	if erc.client == nil {
 		erc.initRpc()
 	}
 	return erc.client
 }
+
+// Transport 返回当前客户端所使用的传输方式
+func (erc *ETHRPCClient) Transport() Transport {
+	return erc.transport
+}
+
+// SupportsSubscription 判断当前传输方式是否支持 eth_subscribe 订阅
+// http 是短连接，只有 websocket 和 ipc 才能订阅
+func (erc *ETHRPCClient) SupportsSubscription() bool {
+	return erc.transport == TransportWebSocket || erc.transport == TransportIPC
+}
+
+// Close 释放对共享 rpc 句柄的引用
+func (erc *ETHRPCClient) Close() {
+	if erc.client == nil {
+		return
+	}
+	releaseRpc(erc.transport, erc.endpoint)
+	erc.client = nil
+}
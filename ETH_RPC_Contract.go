@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ContractCallError 代表 eth_call 执行合约函数失败，通常是合约 revert
+type ContractCallError struct {
+	Method  string // 调用的合约函数名称
+	Message string // 节点返回的错误信息，例如 execution reverted
+	Data    string // 合约 revert 返回的数据，没有时为空
+	Reason  string // 从 Error(string) 中解析出的 revert 原因，解析不出时为空
+}
+
+func (e *ContractCallError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("调用合约函数 %s 失败 %s: %s", e.Method, e.Message, e.Reason)
+	}
+	return fmt.Sprintf("调用合约函数 %s 失败 %s", e.Method, e.Message)
+}
+
+// Contract 根据智能合约的 abi 打包函数的入参和解析返回值，
+// 不需要再手动拼接 data 的十六进制字符串
+type Contract struct {
+	requester *ETHRPCRequester
+	address   common.Address // 合约地址
+	abi       abi.ABI        // 合约的 abi
+}
+
+// NewContract 使用合约地址和 abi 的 json 数据实例化合约
+func NewContract(requester *ETHRPCRequester, address, abiJSON string) (*Contract, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errors.New("invalid contract address")
+	}
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, fmt.Errorf("解析合约 abi 失败 %s", err.Error())
+	}
+	return &Contract{requester: requester, address: common.HexToAddress(address), abi: parsed}, nil
+}
+
+// Address 返回合约地址
+func (c *Contract) Address() common.Address {
+	return c.address
+}
+
+// Pack 按照 abi 打包合约函数的调用数据，包含 methodId 和编码后的入参
+func (c *Contract) Pack(method string, args ...interface{}) ([]byte, error) {
+	data, err := c.abi.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("打包合约函数 %s 的入参失败 %s", method, err.Error())
+	}
+	return data, nil
+}
+
+// Call 在区块 block 的状态下使用 eth_call 调用合约的只读函数，返回按照 abi 解析后的返回值
+// 返回值的类型和 abi 对应，例如 uint256 是 *big.Int
+func (c *Contract) Call(block BlockTag, method string, args ...interface{}) ([]interface{}, error) {
+	ctx, cancel := c.requester.defaultContext()
+	defer cancel()
+	return c.CallContext(ctx, block, method, args...)
+}
+
+// CallContext 在区块 block 的状态下使用 eth_call 调用合约的只读函数，返回按照 abi 解析后的返回值
+// 合约 revert 时返回 *ContractCallError
+func (c *Contract) CallContext(ctx context.Context, block BlockTag, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := c.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	arg := map[string]interface{}{
+		"to":   c.address,
+		"data": hexutil.Bytes(data),
+	}
+	result := hexutil.Bytes{}
+	if err := c.requester.client.CallContext(ctx, &result, "eth_call", arg, block); err != nil {
+		return nil, c.callError(method, err)
+	}
+	if len(result) == 0 && len(c.abi.Methods[method].Outputs) > 0 {
+		// 地址上没有合约代码时节点返回 0x
+		return nil, fmt.Errorf("合约函数 %s 没有返回数据，%s 上可能没有部署合约", method, c.address.Hex())
+	}
+	values, err := c.abi.Unpack(method, result)
+	if err != nil {
+		return nil, fmt.Errorf("解析合约函数 %s 的返回值失败 %s", method, err.Error())
+	}
+	return values, nil
+}
+
+// 把节点返回的 revert 错误转换为 *ContractCallError，网络等节点本身的问题原样返回
+func (c *Contract) callError(method string, err error) error {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return fmt.Errorf("eth_call failed! %s", err.Error())
+	}
+	callErr := &ContractCallError{Method: method, Message: rpcErr.Error()}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			callErr.Data = data
+			if reason, err := abi.UnpackRevert(common.FromHex(data)); err == nil {
+				callErr.Reason = reason
+			}
+		}
+	}
+	return callErr
+}
+
+// Transact 从 from 地址发送调用合约函数的交易，gasLimit 和 gasPrice 自动估算，nonce 由 nonce 管理器预留
+func (c *Contract) Transact(from string, method string, args ...interface{}) (string, error) {
+	ctx, cancel := c.requester.defaultContext()
+	defer cancel()
+	return c.TransactContext(ctx, from, method, args...)
+}
+
+// TransactContext 从 from 地址发送调用合约函数的交易，gasLimit 和 gasPrice 自动估算
+func (c *Contract) TransactContext(ctx context.Context, from string, method string, args ...interface{}) (string, error) {
+	if !common.IsHexAddress(from) {
+		return "", errors.New("invalid address")
+	}
+	data, err := c.Pack(method, args...)
+	if err != nil {
+		return "", err
+	}
+	return c.requester.sendLegacyTransaction(ctx, from, c.address, new(big.Int), 0, 0, data)
+}
+
+// TransactWithFee 从 from 地址发送调用合约函数的 EIP-1559 交易，燃料费由 fee 决定，gasLimit 自动估算
+func (c *Contract) TransactWithFee(from string, fee FeeOption, method string, args ...interface{}) (string, error) {
+	ctx, cancel := c.requester.defaultContext()
+	defer cancel()
+	return c.TransactWithFeeContext(ctx, from, fee, method, args...)
+}
+
+// TransactWithFeeContext 从 from 地址发送调用合约函数的 EIP-1559 交易，燃料费由 fee 决定
+func (c *Contract) TransactWithFeeContext(ctx context.Context, from string, fee FeeOption, method string, args ...interface{}) (string, error) {
+	if !common.IsHexAddress(from) {
+		return "", errors.New("invalid address")
+	}
+	data, err := c.Pack(method, args...)
+	if err != nil {
+		return "", err
+	}
+	return c.requester.sendDynamicFeeTransaction(ctx, from, &c.address, new(big.Int), 0, data, fee)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"eth-relay/model"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// FeeStrategy 是 EIP-1559 交易燃料费的计算策略
+type FeeStrategy string
+
+const (
+	FeeSlow   FeeStrategy = "slow"   // 使用最近区块中 10% 分位的小费，maxFeePerGas 为 baseFee 的 1.25 倍加小费
+	FeeNormal FeeStrategy = "normal" // 使用最近区块中 50% 分位的小费，maxFeePerGas 为 baseFee 的 1.5 倍加小费
+	FeeFast   FeeStrategy = "fast"   // 使用最近区块中 90% 分位的小费，maxFeePerGas 为 baseFee 的 2 倍加小费
+)
+
+// 计算燃料费时参考的区块数量
+const feeHistoryBlocks = 10
+
+// 每种策略在 eth_feeHistory 中的百分位数，以及 baseFee 的倍数（分子/4）
+var feeStrategies = map[FeeStrategy]struct {
+	percentile float64
+	baseFee4x  int64
+}{
+	FeeSlow:   {10, 5},
+	FeeNormal: {50, 6},
+	FeeFast:   {90, 8},
+}
+
+// FeeOption 是 EIP-1559 交易的燃料费设置
+// MaxFeePerGas 和 MaxPriorityFeePerGas 都不为空时直接使用，否则按照 Strategy 计算，Strategy 为空时使用 FeeNormal
+// 只设置其中一个时，设置的那个直接使用，另一个按照 Strategy 计算，小费不能超过 MaxFeePerGas
+type FeeOption struct {
+	Strategy             FeeStrategy
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+}
+
+// DynamicFee 是计算出的 EIP-1559 燃料费
+type DynamicFee struct {
+	MaxFeePerGas         *big.Int // 愿意支付的最高燃料单价，包含 baseFee 和小费
+	MaxPriorityFeePerGas *big.Int // 给矿工的小费单价
+}
+
+// GetFeeHistory 获取最近 blockCount 个区块的 baseFee 和按照 percentiles 百分位数统计的小费
+func (r *ETHRPCRequester) GetFeeHistory(blockCount uint64, percentiles []float64) (*model.FeeHistory, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetFeeHistoryContext(ctx, blockCount, percentiles)
+}
+
+// GetFeeHistoryContext 获取最近 blockCount 个区块的 baseFee 和按照 percentiles 百分位数统计的小费
+func (r *ETHRPCRequester) GetFeeHistoryContext(ctx context.Context, blockCount uint64, percentiles []float64) (*model.FeeHistory, error) {
+	history := model.FeeHistory{}
+	err := r.client.CallContext(ctx, &history, "eth_feeHistory", hexutil.Uint64(blockCount), "latest", percentiles)
+	if err != nil {
+		return nil, fmt.Errorf("获取 fee history 失败 %s", err.Error())
+	}
+	if len(history.BaseFeePerGas) == 0 {
+		return nil, errors.New("fee history is empty")
+	}
+	return &history, nil
+}
+
+// SuggestDynamicFee 根据最近区块的 baseFee 和小费，按照策略计算 EIP-1559 燃料费
+func (r *ETHRPCRequester) SuggestDynamicFee(strategy FeeStrategy) (*DynamicFee, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SuggestDynamicFeeContext(ctx, strategy)
+}
+
+// SuggestDynamicFeeContext 根据最近区块的 baseFee 和小费，按照策略计算 EIP-1559 燃料费
+func (r *ETHRPCRequester) SuggestDynamicFeeContext(ctx context.Context, strategy FeeStrategy) (*DynamicFee, error) {
+	if strategy == "" {
+		strategy = FeeNormal
+	}
+	setting, ok := feeStrategies[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown fee strategy %s", strategy)
+	}
+	history, err := r.GetFeeHistoryContext(ctx, feeHistoryBlocks, []float64{setting.percentile})
+	if err != nil {
+		return nil, err
+	}
+	// 最后一个 baseFee 是下一个区块的 baseFee，交易最早被打包进这个区块
+	baseFee, err := hexutil.DecodeBig(history.BaseFeePerGas[len(history.BaseFeePerGas)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid base fee %s", err.Error())
+	}
+	// 小费取各个区块的中位数，避免个别区块的异常值
+	tips := []*big.Int{}
+	for _, reward := range history.Reward {
+		if len(reward) == 0 {
+			continue
+		}
+		tip, err := hexutil.DecodeBig(reward[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reward %s", err.Error())
+		}
+		tips = append(tips, tip)
+	}
+	tip := new(big.Int)
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		tip = tips[len(tips)/2]
+	}
+	maxFee := new(big.Int).Mul(baseFee, big.NewInt(setting.baseFee4x))
+	maxFee.Div(maxFee, big.NewInt(4))
+	maxFee.Add(maxFee, tip)
+	return &DynamicFee{MaxFeePerGas: maxFee, MaxPriorityFeePerGas: tip}, nil
+}
+
+// 根据燃料费设置得到最终的燃料费
+func (r *ETHRPCRequester) dynamicFee(ctx context.Context, option FeeOption) (*DynamicFee, error) {
+	fee := &DynamicFee{MaxFeePerGas: option.MaxFeePerGas, MaxPriorityFeePerGas: option.MaxPriorityFeePerGas}
+	if fee.MaxFeePerGas == nil || fee.MaxPriorityFeePerGas == nil {
+		suggest, err := r.SuggestDynamicFeeContext(ctx, option.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case fee.MaxFeePerGas == nil && fee.MaxPriorityFeePerGas == nil:
+			fee = suggest
+		case fee.MaxFeePerGas == nil:
+			// 建议的 maxFeePerGas 是 baseFee 的倍数加上建议的小费，换成调用者设置的小费
+			fee.MaxFeePerGas = new(big.Int).Sub(suggest.MaxFeePerGas, suggest.MaxPriorityFeePerGas)
+			fee.MaxFeePerGas.Add(fee.MaxFeePerGas, fee.MaxPriorityFeePerGas)
+		default:
+			fee.MaxPriorityFeePerGas = suggest.MaxPriorityFeePerGas
+		}
+	}
+	if fee.MaxPriorityFeePerGas.Cmp(fee.MaxFeePerGas) > 0 {
+		return nil, fmt.Errorf("maxPriorityFeePerGas %s is greater than maxFeePerGas %s", fee.MaxPriorityFeePerGas.String(), fee.MaxFeePerGas.String())
+	}
+	return fee, nil
+}
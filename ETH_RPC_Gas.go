@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrGasCapExceeded 代表估算出的燃料数量超过了设置的上限
+var ErrGasCapExceeded = errors.New("gas cap exceeded")
+
+// GasEstimationError 代表节点估算燃料失败，通常是交易执行会失败，例如 ERC20 余额不足导致 revert
+// 这种交易即使发送出去也会失败，所以在签名之前就返回
+type GasEstimationError struct {
+	Message string // 节点返回的错误信息，例如 execution reverted
+	Data    string // 合约 revert 返回的数据，没有时为空
+}
+
+func (e *GasEstimationError) Error() string {
+	if e.Data == "" {
+		return fmt.Sprintf("估算燃料失败 %s", e.Message)
+	}
+	return fmt.Sprintf("估算燃料失败 %s %s", e.Message, e.Data)
+}
+
+// 自动估算燃料的设置，ETHRPCRequester 按值复制后仍然共用同一份设置
+type gasConfig struct {
+	lock       sync.RWMutex
+	multiplier float64           // 估算结果乘上的安全系数
+	caps       map[string]uint64 // 每个代币合约的燃料上限，key 为小写的合约地址，ETH 转账的 key 为空字符串
+}
+
+// SetGasMultiplier 设置自动估算燃料时的安全系数，默认是 1.2
+func (r *ETHRPCRequester) SetGasMultiplier(multiplier float64) {
+	r.gas.lock.Lock()
+	defer r.gas.lock.Unlock()
+	r.gas.multiplier = multiplier
+}
+
+// SetGasCap 设置代币合约 contract 自动估算燃料时的上限，contract 为空字符串时设置的是 ETH 转账的上限
+// 乘上安全系数后超过上限时使用上限，估算结果本身超过上限时返回 ErrGasCapExceeded
+func (r *ETHRPCRequester) SetGasCap(contract string, gasCap uint64) {
+	r.gas.lock.Lock()
+	defer r.gas.lock.Unlock()
+	r.gas.caps[strings.ToLower(contract)] = gasCap
+}
+
+// GetGasPrice 获取节点建议的燃料单价
+func (r *ETHRPCRequester) GetGasPrice() (*big.Int, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetGasPriceContext(ctx)
+}
+
+// GetGasPriceContext 获取节点建议的燃料单价
+func (r *ETHRPCRequester) GetGasPriceContext(ctx context.Context) (*big.Int, error) {
+	result := hexutil.Big{}
+	if err := r.client.CallContext(ctx, &result, "eth_gasPrice"); err != nil {
+		return nil, fmt.Errorf("获取燃料单价失败 %s", err.Error())
+	}
+	return result.ToInt(), nil
+}
+
+// EstimateGas 使用 eth_estimateGas 估算交易需要的燃料数量，返回的是没有乘上安全系数的原始结果
+// 节点估算失败时返回 *GasEstimationError
+func (r *ETHRPCRequester) EstimateGas(from string, to *common.Address, value *big.Int, data []byte) (uint64, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.EstimateGasContext(ctx, from, to, value, data)
+}
+
+// EstimateGasContext 使用 eth_estimateGas 估算交易需要的燃料数量
+func (r *ETHRPCRequester) EstimateGasContext(ctx context.Context, from string, to *common.Address, value *big.Int, data []byte) (uint64, error) {
+	arg := map[string]interface{}{
+		"from": common.HexToAddress(from),
+		"data": hexutil.Bytes(data),
+	}
+	if to != nil {
+		arg["to"] = to
+	}
+	if value != nil && value.Sign() > 0 {
+		arg["value"] = (*hexutil.Big)(value)
+	}
+	result := hexutil.Uint64(0)
+	err := r.client.CallContext(ctx, &result, "eth_estimateGas", arg)
+	if err == nil {
+		return uint64(result), nil
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		// 网络等节点本身的问题，不是交易的问题
+		return 0, fmt.Errorf("估算燃料失败 %s", err.Error())
+	}
+	estimationErr := &GasEstimationError{Message: rpcErr.Error()}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			estimationErr.Data = data
+		}
+	}
+	return 0, estimationErr
+}
+
+// 调用者没有指定 gasLimit 时自动估算，结果乘上安全系数并且受到代币燃料上限的限制
+func (r *ETHRPCRequester) fillGasLimit(ctx context.Context, from string, to *common.Address, value *big.Int, data []byte, gasLimit uint64) (uint64, error) {
+	if gasLimit > 0 {
+		return gasLimit, nil
+	}
+	estimate, err := r.EstimateGasContext(ctx, from, to, value, data)
+	if err != nil {
+		return 0, err
+	}
+	capKey := "" // ETH 转账
+	if len(data) > 0 && to != nil {
+		capKey = strings.ToLower(to.Hex())
+	}
+	r.gas.lock.RLock()
+	multiplier, gasCap := r.gas.multiplier, r.gas.caps[capKey]
+	r.gas.lock.RUnlock()
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	limit := uint64(math.Ceil(float64(estimate) * multiplier))
+	if gasCap > 0 && limit > gasCap {
+		if estimate > gasCap {
+			return 0, fmt.Errorf("%w: 估算结果 %d，上限 %d", ErrGasCapExceeded, estimate, gasCap)
+		}
+		limit = gasCap
+	}
+	return limit, nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// NodeConfig 是节点池中单个节点的配置
+type NodeConfig struct {
+	Url      string // 节点的链接
+	Priority int    // 优先级，数值越小越优先使用
+}
+
+// NodeStatus 是节点当前的健康状态，供外部查看
+type NodeStatus struct {
+	Url       string        // 节点的链接
+	Priority  int           // 优先级
+	Healthy   bool          // 最近一次健康检查是否成功
+	Head      uint64        // 节点的最新区块号
+	Lag       uint64        // 落后于所有节点中最高区块号的数量
+	Latency   time.Duration // 最近一次 eth_blockNumber 的耗时
+	LastError error         // 最近一次的错误
+}
+
+// 节点池中的单个节点
+type poolNode struct {
+	config    NodeConfig
+	client    *ETHRPCClient
+	healthy   bool
+	head      uint64
+	latency   time.Duration
+	lastError error
+}
+
+// ETHRPCClientPool 是多节点的 rpc 客户端池
+// 它定时使用 eth_blockNumber 检查每个节点的健康状况和区块落后情况，
+// 每次调用都发送给当前最健康的节点，节点出错时自动切换到下一个
+type ETHRPCClientPool struct {
+	nodes         []*poolNode
+	lock          sync.RWMutex  // 保护节点的状态
+	maxHeadLag    uint64        // 落后超过这个区块数的节点会被降级
+	checkInterval time.Duration // 健康检查的间隔
+	chainId       *big.Int      // 节点应该所在的链，不为空时健康检查会校验节点的 eth_chainId
+	stop          chan struct{} // 用来停止健康检查协程
+	once          sync.Once
+}
+
+// NewETHRPCClientPool 根据节点配置数组实例化节点池，并启动后台健康检查
+// 至少要有一个节点能够连接成功，否则返回错误
+func NewETHRPCClientPool(configs []NodeConfig) (*ETHRPCClientPool, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("node list is empty")
+	}
+	pool := &ETHRPCClientPool{
+		maxHeadLag:    5,
+		checkInterval: 10 * time.Second,
+		stop:          make(chan struct{}),
+	}
+	var lastErr error
+	connected := 0
+	for _, config := range configs {
+		node := &poolNode{config: config, client: newETHRPCClient(config.Url)}
+		if err := node.client.initRpc(); err != nil {
+			// 连接失败的节点先标记为不健康，健康检查时会重新连接
+			node.lastError = err
+			lastErr = err
+		} else {
+			node.healthy = true
+			connected++
+		}
+		pool.nodes = append(pool.nodes, node)
+	}
+	if connected == 0 {
+		return nil, fmt.Errorf("所有节点都连接失败 %w", lastErr)
+	}
+	go pool.healthCheckLoop()
+	return pool, nil
+}
+
+// SetMaxHeadLag 设置允许节点落后的最大区块数
+func (p *ETHRPCClientPool) SetMaxHeadLag(lag uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maxHeadLag = lag
+}
+
+// SetHealthCheckInterval 设置健康检查的间隔，下一轮检查开始生效
+func (p *ETHRPCClientPool) SetHealthCheckInterval(interval time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.checkInterval = interval
+}
+
+// SetChainId 设置节点应该所在链的 chain id，健康检查时 eth_chainId 不一致的节点标记为不健康，
+// 避免切换节点后把交易发送到另一条链的节点上
+// chain id 有变化时立即进行一次健康检查，返回时其它链的节点已经被标记为不健康
+func (p *ETHRPCClientPool) SetChainId(chainId *big.Int) {
+	p.lock.Lock()
+	if p.chainId != nil && p.chainId.Cmp(chainId) == 0 {
+		p.lock.Unlock()
+		return
+	}
+	p.chainId = new(big.Int).Set(chainId)
+	p.lock.Unlock()
+	p.CheckHealth()
+}
+
+// GetRpc 返回当前最健康节点的 rpc 句柄，所有节点都不可用时返回 nil
+func (p *ETHRPCClientPool) GetRpc() *rpc.Client {
+	for _, node := range p.candidates() {
+		if client := p.nodeRpc(node); client != nil {
+			return client
+		}
+	}
+	return nil
+}
+
+// Transport 返回当前最健康节点的传输方式
+func (p *ETHRPCClientPool) Transport() Transport {
+	candidates := p.candidates()
+	return candidates[0].client.Transport()
+}
+
+// SupportsSubscription 判断当前最健康的节点是否支持订阅
+func (p *ETHRPCClientPool) SupportsSubscription() bool {
+	candidates := p.candidates()
+	return candidates[0].client.SupportsSubscription()
+}
+
+// Call 将请求发送给最健康的节点，节点本身出错时切换到下一个节点重试
+func (p *ETHRPCClientPool) Call(result interface{}, method string, args ...interface{}) error {
+	return p.CallContext(context.Background(), result, method, args...)
+}
+
+// CallContext 和 Call 一样，ctx 超时或者被取消后不再重试其它节点
+func (p *ETHRPCClientPool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return p.do(ctx, func(client *rpc.Client) error {
+		return client.CallContext(ctx, result, method, args...)
+	})
+}
+
+// BatchCall 将批量请求发送给最健康的节点，节点本身出错时切换到下一个节点重试
+func (p *ETHRPCClientPool) BatchCall(b []rpc.BatchElem) error {
+	return p.BatchCallContext(context.Background(), b)
+}
+
+// BatchCallContext 和 BatchCall 一样，ctx 超时或者被取消后不再重试其它节点
+func (p *ETHRPCClientPool) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return p.do(ctx, func(client *rpc.Client) error {
+		// 重试前清空上一个节点留下的错误
+		for i := range b {
+			b[i].Error = nil
+		}
+		return client.BatchCallContext(ctx, b)
+	})
+}
+
+// 按照节点的健康程度依次尝试，直到调用成功或者节点返回了业务错误
+// 所有节点都失败时返回的错误包装了最后一个节点的错误
+func (p *ETHRPCClientPool) do(ctx context.Context, call func(client *rpc.Client) error) error {
+	var lastErr error
+	for _, node := range p.candidates() {
+		client := p.nodeRpc(node)
+		if client == nil {
+			// 节点还没有连接成功，保留连接失败的原因，所有节点都失败时调用方可以用 errors.Is 判断 ErrDialFailed
+			p.lock.RLock()
+			if node.lastError != nil {
+				lastErr = node.lastError
+			}
+			p.lock.RUnlock()
+			continue
+		}
+		err := call(client)
+		if err == nil || !isNodeFailure(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			// 调用方的超时或者取消，不是节点的问题
+			return err
+		}
+		// 节点故障，标记为不健康，然后切换到下一个节点
+		p.markFailed(node, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no available node")
+	}
+	return fmt.Errorf("所有节点请求失败 %w", lastErr)
+}
+
+// 判断错误是否是节点本身的故障
+// 节点返回的 json-rpc 错误（例如参数错误、合约执行失败）换节点也没用，不进行切换
+func isNodeFailure(err error) bool {
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// 获取节点的 rpc 句柄，节点还没有连接成功时返回 nil
+func (p *ETHRPCClientPool) nodeRpc(node *poolNode) *rpc.Client {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return node.client.client
+}
+
+// 将节点标记为不健康
+func (p *ETHRPCClientPool) markFailed(node *poolNode, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	node.healthy = false
+	node.lastError = err
+}
+
+// 返回按照健康程度排序后的节点数组，第一个是最健康的
+func (p *ETHRPCClientPool) candidates() []*poolNode {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	maxHead := p.maxHead()
+	nodes := append([]*poolNode{}, p.nodes...)
+	// 分级：健康且不落后 > 健康但落后 > 不健康，同级内按照优先级、落后数、耗时排序
+	tier := func(node *poolNode) int {
+		if !node.healthy {
+			return 2
+		}
+		if maxHead-node.head > p.maxHeadLag {
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if tier(a) != tier(b) {
+			return tier(a) < tier(b)
+		}
+		if a.config.Priority != b.config.Priority {
+			return a.config.Priority < b.config.Priority
+		}
+		if a.head != b.head {
+			return a.head > b.head
+		}
+		return a.latency < b.latency
+	})
+	return nodes
+}
+
+// 所有健康节点中最高的区块号，调用者需要持有锁
+func (p *ETHRPCClientPool) maxHead() uint64 {
+	maxHead := uint64(0)
+	for _, node := range p.nodes {
+		if node.healthy && node.head > maxHead {
+			maxHead = node.head
+		}
+	}
+	return maxHead
+}
+
+// Status 返回所有节点当前的状态
+func (p *ETHRPCClientPool) Status() []NodeStatus {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	maxHead := p.maxHead()
+	status := []NodeStatus{}
+	for _, node := range p.nodes {
+		lag := uint64(0)
+		if node.head < maxHead {
+			lag = maxHead - node.head
+		}
+		status = append(status, NodeStatus{
+			Url:       node.config.Url,
+			Priority:  node.config.Priority,
+			Healthy:   node.healthy,
+			Head:      node.head,
+			Lag:       lag,
+			Latency:   node.latency,
+			LastError: node.lastError,
+		})
+	}
+	return status
+}
+
+// 后台健康检查协程
+func (p *ETHRPCClientPool) healthCheckLoop() {
+	for {
+		p.CheckHealth()
+		p.lock.RLock()
+		interval := p.checkInterval
+		p.lock.RUnlock()
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CheckHealth 立即对所有节点进行一次健康检查
+func (p *ETHRPCClientPool) CheckHealth() {
+	wg := sync.WaitGroup{}
+	for _, node := range p.nodes {
+		wg.Add(1)
+		go func(node *poolNode) {
+			defer wg.Done()
+			p.checkNode(node)
+		}(node)
+	}
+	wg.Wait()
+}
+
+// 对单个节点调用 eth_blockNumber，记录区块号和耗时，设置了 chain id 时还会校验节点的 eth_chainId
+func (p *ETHRPCClientPool) checkNode(node *poolNode) {
+	select {
+	case <-p.stop: // 节点池已经关闭
+		return
+	default:
+	}
+	client := p.nodeRpc(node)
+	if client == nil {
+		// 之前没有连接成功，重新连接
+		rpcClient, err := acquireRpc(node.client.transport, node.client.endpoint)
+		if err != nil {
+			p.markFailed(node, fmt.Errorf("%w: %s", ErrDialFailed, err.Error()))
+			return
+		}
+		p.lock.Lock()
+		node.client.client = rpcClient
+		p.lock.Unlock()
+		client = rpcClient
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	number := ""
+	start := time.Now()
+	err := client.CallContext(ctx, &number, "eth_blockNumber")
+	latency := time.Since(start)
+	if err != nil {
+		p.markFailed(node, err)
+		return
+	}
+	// 节点返回的区块号格式错误时也视为不健康，不能让健康检查协程 panic
+	head, err := hexutil.DecodeUint64(number)
+	if err != nil {
+		p.markFailed(node, fmt.Errorf("invalid block number %q: %s", number, err.Error()))
+		return
+	}
+	p.lock.RLock()
+	expected := p.chainId
+	p.lock.RUnlock()
+	if expected != nil {
+		chainId := hexutil.Big{}
+		if err := client.CallContext(ctx, &chainId, "eth_chainId"); err != nil {
+			p.markFailed(node, err)
+			return
+		}
+		if chainId.ToInt().Cmp(expected) != 0 {
+			p.markFailed(node, fmt.Errorf("%w: 应该是 %s，节点的是 %s", ErrChainIdMismatch, expected.String(), chainId.ToInt().String()))
+			return
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.chainId != expected {
+		return // 检查期间设置了新的 chain id，以设置之后的那次检查为准
+	}
+	node.healthy = true
+	node.head = head
+	node.latency = latency
+	node.lastError = nil
+}
+
+// Close 停止健康检查并释放所有节点的连接
+func (p *ETHRPCClientPool) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		for _, node := range p.nodes {
+			node.client.Close()
+			node.healthy = false
+		}
+	})
+}
@@ -1,46 +1,120 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"eth-relay/model"
 	"eth-relay/tool"
 	"fmt"
 	"math/big"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// ErrReceiptNotFound 代表交易还没有被打包，节点上查不到收据
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ErrNonceUsed 代表广播失败是因为交易的 nonce 已经被其它交易使用
+var ErrNonceUsed = errors.New("nonce already used")
+
+// ErrSendUncertain 代表广播交易时连接出错或者超时，节点可能已经收到了交易，
+// 此时会同时返回签名后的交易哈希值，调用者应该先查询这笔交易，不要直接重新发送
+var ErrSendUncertain = errors.New("transaction may have been sent")
+
+// ETHRPCRequester 的每个请求函数都有一个 Context 版本，使用传入的 ctx 控制超时和取消，
+// 不带 Context 的版本使用 defaultTimeout 作为超时时间
 type ETHRPCRequester struct {
-	nonceManager *NonceManager // noce 管理器实例
-	client       *ETHRPCClient // rpc 客户端
+	nonceManager   *NonceManager       // noce 管理器实例
+	client         *ETHRPCClientPool   // rpc 客户端节点池
+	defaultTimeout time.Duration       // 不带 Context 的函数所使用的默认超时时间
+	chain          *chainIdCache       // 签名交易时使用的 chain id
+	gas            *gasConfig          // 自动估算燃料的设置
+	tracker        *TransactionTracker // 交易追踪器，为空时不追踪广播的交易
+	tokens         *TokenRegistry      // 代币信息注册表，decimal 为 DecimalAuto 时从这里获取
+	signers        *signerSet          // 发送交易时使用的签名者
+	caller         string              // 调用者的身份，签名时记录到审计日志中
+}
+
+// NewETHRPCRequester 实例化，只使用 nodeUrl 这一个节点
+// signers 是发送交易时使用的签名者，地址没有对应的签名者时使用 tool 包中全局解锁的钱包
+// 连接节点失败时返回的错误包含 ErrDialFailed
+func NewETHRPCRequester(nodeUrl string, signers ...tool.Signer) (*ETHRPCRequester, error) {
+	// 实例化只有一个节点的 rpc 客户端节点池
+	pool, err := NewETHRPCClientPool([]NodeConfig{{Url: nodeUrl}})
+	if err != nil {
+		return nil, err
+	}
+	return NewETHRPCRequesterWithPool(pool, signers...), nil
 }
 
-// NewETHRPCRequester 实例化
-func NewETHRPCRequester(nodeUrl string) *ETHRPCRequester {
+// NewETHRPCRequesterWithPool 使用多节点的节点池实例化，请求会自动发送给最健康的节点
+func NewETHRPCRequesterWithPool(pool *ETHRPCClientPool, signers ...tool.Signer) *ETHRPCRequester {
 	requester := &ETHRPCRequester{}
+	requester.signers = &signerSet{signers: map[common.Address]tool.Signer{}}
+	for _, signer := range signers {
+		requester.AddSigner(signer)
+	}
 	// 实例化 noce 管理器
 	requester.nonceManager = NewNonceManager()
-	// 实例化 rpc 客户端
-	requester.client = NewETHRPCClient(nodeUrl)
+	requester.client = pool
+	requester.defaultTimeout = 30 * time.Second
+	requester.chain = &chainIdCache{}
+	requester.gas = &gasConfig{multiplier: 1.2, caps: map[string]uint64{}}
+	requester.tokens = NewTokenRegistry(requester)
 	return requester
 }
 
+// Close 停止节点池的健康检查并释放节点的连接，不再使用请求者时调用
+// 通过 NewETHRPCRequesterWithPool 传入的节点池也会被关闭
+func (r *ETHRPCRequester) Close() {
+	r.client.Close()
+}
+
+// SetDefaultTimeout 设置不带 Context 的函数所使用的默认超时时间，0 代表不超时
+func (r *ETHRPCRequester) SetDefaultTimeout(timeout time.Duration) {
+	r.defaultTimeout = timeout
+}
+
+// 根据默认超时时间生成 context
+func (r *ETHRPCRequester) defaultContext() (context.Context, context.CancelFunc) {
+	if r.defaultTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.defaultTimeout)
+}
+
 // GetTransactionByHash 根据交易的哈希值获取对应的交易信息
 func (r *ETHRPCRequester) GetTransactionByHash(txHash string) (model.Transaction, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetTransactionByHashContext(ctx, txHash)
+}
+
+// GetTransactionByHashContext 根据交易的哈希值获取对应的交易信息
+func (r *ETHRPCRequester) GetTransactionByHashContext(ctx context.Context, txHash string) (model.Transaction, error) {
 	methodName := "eth_getTransactionByHash"
 	result := model.Transaction{}
 	// 下面 call 函数的 result 参数传入的是 model.Transaction 结构体的引用
 	// 这样内部所设置的值在函数执行完之后才能有效果
-	err := r.client.GetRpc().Call(&result, methodName, txHash)
+	err := r.client.CallContext(ctx, &result, methodName, txHash)
 	return result, err
 }
 
-// 根据交易哈希值字符串的数组批量获取对应的交易信息
+// GetTransactions 根据交易哈希值字符串的数组批量获取对应的交易信息
 func (r *ETHRPCRequester) GetTransactions(txHashs []string) ([]*model.Transaction, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetTransactionsContext(ctx, txHashs)
+}
+
+// GetTransactionsContext 根据交易哈希值字符串的数组批量获取对应的交易信息
+func (r *ETHRPCRequester) GetTransactionsContext(ctx context.Context, txHashs []string) ([]*model.Transaction, error) {
 	name := "eth_getTransactionByHash"
 	// 结果数组存储的是每个请求的结果指针，也就是引用
 	results := []*model.Transaction{}
@@ -63,16 +137,94 @@ func (r *ETHRPCRequester) GetTransactions(txHashs []string) ([]*model.Transactio
 		results = append(results, &result)
 	}
 	// 传入 BatchElem 数组，发起批量请求
-	err := r.client.GetRpc().BatchCall(requesters)
+	err := r.client.BatchCallContext(ctx, requesters)
 	return results, err
 }
 
-// 单笔查询，根据以太坊地址，查询以太坊 eth 的余额
+// GetTransactionReceipt 根据交易的哈希值获取交易收据
+// 交易还没有被打包时返回 ErrReceiptNotFound
+func (r *ETHRPCRequester) GetTransactionReceipt(txHash string) (*model.Receipt, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetTransactionReceiptContext(ctx, txHash)
+}
+
+// GetTransactionReceiptContext 根据交易的哈希值获取交易收据
+func (r *ETHRPCRequester) GetTransactionReceiptContext(ctx context.Context, txHash string) (*model.Receipt, error) {
+	methodName := "eth_getTransactionReceipt"
+	// 交易还没有被打包时节点返回 null，result 会保持为 nil
+	var result *model.Receipt
+	if err := r.client.CallContext(ctx, &result, methodName, txHash); err != nil {
+		return nil, fmt.Errorf("获取交易收据失败！ %s", err.Error())
+	}
+	if result == nil {
+		return nil, ErrReceiptNotFound
+	}
+	return result, nil
+}
+
+// GetTransactionReceipts 根据交易哈希值字符串的数组批量获取交易收据
+// 返回的数组和 txHashs 一一对应，还没有被打包的交易对应的收据是 nil
+func (r *ETHRPCRequester) GetTransactionReceipts(txHashs []string) ([]*model.Receipt, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetTransactionReceiptsContext(ctx, txHashs)
+}
+
+// GetTransactionReceiptsContext 根据交易哈希值字符串的数组批量获取交易收据
+func (r *ETHRPCRequester) GetTransactionReceiptsContext(ctx context.Context, txHashs []string) ([]*model.Receipt, error) {
+	name := "eth_getTransactionReceipt"
+	results := make([]*model.Receipt, len(txHashs))
+	reqs := []rpc.BatchElem{}
+	for i := range txHashs {
+		reqs = append(reqs, rpc.BatchElem{
+			Method: name,
+			Args:   []interface{}{txHashs[i]},
+			// 传入数组元素的引用，null 的结果会保持为 nil
+			Result: &results[i],
+		})
+	}
+	if len(reqs) == 0 {
+		return results, nil
+	}
+	// 传入 BatchElem 数组，发起批量请求
+	if err := r.client.BatchCallContext(ctx, reqs); err != nil {
+		return nil, err
+	}
+	// 查询每个请求有没有错误
+	for _, req := range reqs {
+		if req.Error != nil {
+			return nil, req.Error
+		}
+	}
+	return results, nil
+}
+
+// GetETHBalance 单笔查询，根据以太坊地址，查询以太坊 eth 的余额
 func (r *ETHRPCRequester) GetETHBalance(address string) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetETHBalanceContext(ctx, address)
+}
+
+// GetETHBalanceContext 单笔查询，根据以太坊地址，查询以太坊 eth 的余额
+func (r *ETHRPCRequester) GetETHBalanceContext(ctx context.Context, address string) (string, error) {
+	return r.GetETHBalanceAtContext(ctx, address, LatestBlock)
+}
+
+// GetETHBalanceAt 单笔查询，根据以太坊地址，查询以太坊 eth 在 block 时的余额
+func (r *ETHRPCRequester) GetETHBalanceAt(address string, block BlockTag) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetETHBalanceAtContext(ctx, address, block)
+}
+
+// GetETHBalanceAtContext 单笔查询，根据以太坊地址，查询以太坊 eth 在 block 时的余额
+func (r *ETHRPCRequester) GetETHBalanceAtContext(ctx context.Context, address string, block BlockTag) (string, error) {
 	name := "eth_getBalance"
 	result := ""
-	// 对应文档，第一个参数就是要查询的以太坊地址，第二个参数是 latest
-	err := r.client.GetRpc().Call(&result, name, address, "latest")
+	// 对应文档，第一个参数就是要查询的以太坊地址，第二个参数是区块
+	err := r.client.CallContext(ctx, &result, name, address, block)
 	if err != nil {
 		return "", err
 	}
@@ -86,8 +238,27 @@ func (r *ETHRPCRequester) GetETHBalance(address string) (string, error) {
 	return ten.String(), nil
 }
 
-// 批量查询，根据以太坊地址数组，查询以太坊 eth 的余额
+// GetETHBalances 批量查询，根据以太坊地址数组，查询以太坊 eth 的余额
 func (r *ETHRPCRequester) GetETHBalances(addresss []string) ([]string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetETHBalancesContext(ctx, addresss)
+}
+
+// GetETHBalancesContext 批量查询，根据以太坊地址数组，查询以太坊 eth 的余额
+func (r *ETHRPCRequester) GetETHBalancesContext(ctx context.Context, addresss []string) ([]string, error) {
+	return r.GetETHBalancesAtContext(ctx, addresss, LatestBlock)
+}
+
+// GetETHBalancesAt 批量查询，根据以太坊地址数组，查询以太坊 eth 在同一个区块 block 时的余额
+func (r *ETHRPCRequester) GetETHBalancesAt(addresss []string, block BlockTag) ([]string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetETHBalancesAtContext(ctx, addresss, block)
+}
+
+// GetETHBalancesAtContext 批量查询，根据以太坊地址数组，查询以太坊 eth 在同一个区块 block 时的余额
+func (r *ETHRPCRequester) GetETHBalancesAtContext(ctx context.Context, addresss []string, block BlockTag) ([]string, error) {
 	name := "eth_getBalance"
 	// 结果数组存储的是每个请求的结果指针，也就是引用
 	rets := []*string{}
@@ -99,7 +270,7 @@ func (r *ETHRPCRequester) GetETHBalances(addresss []string) ([]string, error) {
 		// 实例化每个 BatchElem
 		req := rpc.BatchElem{
 			Method: name,
-			Args:   []interface{}{addresss[i], "latest"},
+			Args:   []interface{}{addresss[i], block},
 			// &ret 传入单个请求的结果引用，保证它在函数内部被修改值后，回到函数外时仍然有效
 			Result: &ret,
 		}
@@ -109,7 +280,7 @@ func (r *ETHRPCRequester) GetETHBalances(addresss []string) ([]string, error) {
 		rets = append(rets, &ret)
 	}
 	// 传入 BatchElem 数组，发起批量请求
-	err := r.client.GetRpc().BatchCall(reqs)
+	err := r.client.BatchCallContext(ctx, reqs)
 	if err != nil {
 		return nil, err
 	}
@@ -131,11 +302,30 @@ func (r *ETHRPCRequester) GetETHBalances(addresss []string) ([]string, error) {
 type ERC20BalanceRpcReq struct {
 	ContractAddress string // 合约的以太坊地址
 	UserAddress     string // 用户的以太坊地址
-	ContractDecimal int    // 合约所对应代币单位精确到小数点后的位数
+	ContractDecimal int    // 合约所对应代币单位精确到小数点后的位数，DecimalAuto 代表从代币信息注册表获取
 }
 
-// 批量查询：根据以太坊地址数组，查询 ERC20 代币的余额
+// GetERC20Balances 批量查询：根据以太坊地址数组，查询 ERC20 代币的余额
 func (r *ETHRPCRequester) GetERC20Balances(paramArr []ERC20BalanceRpcReq) ([]string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetERC20BalancesContext(ctx, paramArr)
+}
+
+// GetERC20BalancesContext 批量查询：根据以太坊地址数组，查询 ERC20 代币的余额
+func (r *ETHRPCRequester) GetERC20BalancesContext(ctx context.Context, paramArr []ERC20BalanceRpcReq) ([]string, error) {
+	return r.GetERC20BalancesAtContext(ctx, paramArr, LatestBlock)
+}
+
+// GetERC20BalancesAt 批量查询：根据以太坊地址数组，查询 ERC20 代币在同一个区块 block 时的余额
+func (r *ETHRPCRequester) GetERC20BalancesAt(paramArr []ERC20BalanceRpcReq, block BlockTag) ([]string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetERC20BalancesAtContext(ctx, paramArr, block)
+}
+
+// GetERC20BalancesAtContext 批量查询：根据以太坊地址数组，查询 ERC20 代币在同一个区块 block 时的余额
+func (r *ETHRPCRequester) GetERC20BalancesAtContext(ctx context.Context, paramArr []ERC20BalanceRpcReq, block BlockTag) ([]string, error) {
 	name := "eth_call"
 	methodId := "0x70a08231" // 这个是 balanceOf 的 methodId
 	// 结果数组存储的是每个请求的结果指针，也就是引用
@@ -153,7 +343,7 @@ func (r *ETHRPCRequester) GetERC20Balances(paramArr []ERC20BalanceRpcReq) ([]str
 		// 实例化每个 BatchElem
 		req := rpc.BatchElem{
 			Method: name,
-			Args:   []interface{}{arg, "latest"},
+			Args:   []interface{}{arg, block},
 			// &ret 传入单个请求的结果引用，保证它在函数内部被修改值后，回到函数外时仍然有效
 			Result: &ret,
 		}
@@ -164,7 +354,7 @@ func (r *ETHRPCRequester) GetERC20Balances(paramArr []ERC20BalanceRpcReq) ([]str
 		rets = append(rets, &ret)
 	}
 	// 传入 BatchElem 数组，发起批量请求
-	err := r.client.GetRpc().BatchCall(reqs)
+	err := r.client.BatchCallContext(ctx, reqs)
 	if err != nil {
 		return nil, err
 	}
@@ -185,12 +375,89 @@ func (r *ETHRPCRequester) GetERC20Balances(paramArr []ERC20BalanceRpcReq) ([]str
 	return finalRet, err
 }
 
-// 获取以太坊最新生成区块的区块号
+// GetERC20BalancesFormatted 批量查询 ERC20 代币在区块 block 时的余额，结果已经除以 10^ContractDecimal
+func (r *ETHRPCRequester) GetERC20BalancesFormatted(paramArr []ERC20BalanceRpcReq, block BlockTag) ([]string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetERC20BalancesFormattedContext(ctx, paramArr, block)
+}
+
+// GetERC20BalancesFormattedContext 批量查询 ERC20 代币在区块 block 时的余额，结果已经除以 10^ContractDecimal
+// ContractDecimal 为 DecimalAuto 时使用代币信息注册表中的 decimal
+func (r *ETHRPCRequester) GetERC20BalancesFormattedContext(ctx context.Context, paramArr []ERC20BalanceRpcReq, block BlockTag) ([]string, error) {
+	decimals := make([]int, len(paramArr))
+	for i, param := range paramArr {
+		decimal, err := r.resolveDecimal(ctx, param.ContractAddress, param.ContractDecimal)
+		if err != nil {
+			return nil, err
+		}
+		decimals[i] = decimal
+	}
+	balances, err := r.GetERC20BalancesAtContext(ctx, paramArr, block)
+	if err != nil {
+		return nil, err
+	}
+	if len(balances) != len(paramArr) {
+		return nil, fmt.Errorf("查询到的余额数量 %d 和参数数量 %d 不一致", len(balances), len(paramArr))
+	}
+	for i := range balances {
+		balances[i] = tool.FormatDecimalValue(balances[i], decimals[i])
+	}
+	return balances, nil
+}
+
+// SetTokenRegistry 设置代币信息注册表，多个实例共用代币信息时传入 NewTokenRegistryWithDB 的注册表
+func (r *ETHRPCRequester) SetTokenRegistry(registry *TokenRegistry) {
+	r.tokens = registry
+}
+
+// TokenRegistry 返回代币信息注册表
+func (r *ETHRPCRequester) TokenRegistry() *TokenRegistry {
+	return r.tokens
+}
+
+// decimal 为 DecimalAuto 时从代币信息注册表获取代币的 decimal
+func (r *ETHRPCRequester) resolveDecimal(ctx context.Context, contract string, decimal int) (int, error) {
+	if decimal == DecimalAuto {
+		return r.tokens.DecimalsContext(ctx, contract)
+	}
+	if decimal < 0 {
+		return 0, fmt.Errorf("invalid decimal %d", decimal)
+	}
+	return decimal, nil
+}
+
+// SupportsSubscription 判断当前节点是否支持 eth_subscribe 订阅
+func (r *ETHRPCRequester) SupportsSubscription() bool {
+	return r.client.SupportsSubscription()
+}
+
+// SubscribeNewHeads 订阅新区块头，新区块生成时节点会把区块头推送到 ch
+// 只有 websocket 和 ipc 节点支持，订阅断开的原因从返回值的 Err() 中获取
+func (r *ETHRPCRequester) SubscribeNewHeads(ctx context.Context, ch chan<- *model.BlockHeader) (*rpc.ClientSubscription, error) {
+	if !r.client.SupportsSubscription() {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	client := r.client.GetRpc()
+	if client == nil {
+		return nil, errors.New("no available node")
+	}
+	return client.EthSubscribe(ctx, ch, "newHeads")
+}
+
+// GetLatestBlockNumber 获取以太坊最新生成区块的区块号
 func (r *ETHRPCRequester) GetLatestBlockNumber() (*big.Int, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetLatestBlockNumberContext(ctx)
+}
+
+// GetLatestBlockNumberContext 获取以太坊最新生成区块的区块号
+func (r *ETHRPCRequester) GetLatestBlockNumberContext(ctx context.Context) (*big.Int, error) {
 	methodName := "eth_blockNumber"
 	number := "" // 存储结果
 	// eth_blockNumber 不需要参数
-	err := r.client.client.Call(&number, methodName)
+	err := r.client.CallContext(ctx, &number, methodName)
 	if err != nil {
 		return nil, fmt.Errorf("获取最新区块号失败！ %s", err.Error())
 	}
@@ -199,14 +466,21 @@ func (r *ETHRPCRequester) GetLatestBlockNumber() (*big.Int, error) {
 	return ten, nil
 }
 
-// 根据区块号获取区块信息
+// GetBlockInfoByNumber 根据区块号获取区块信息
 func (r *ETHRPCRequester) GetBlockInfoByNumber(blockNumber *big.Int) (*model.FullBlock, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetBlockInfoByNumberContext(ctx, blockNumber)
+}
+
+// GetBlockInfoByNumberContext 根据区块号获取区块信息
+func (r *ETHRPCRequester) GetBlockInfoByNumberContext(ctx context.Context, blockNumber *big.Int) (*model.FullBlock, error) {
 	number := fmt.Sprintf("%#x", blockNumber) // 将 big.Int 转为 十六进制字符串
 	methodName := "eth_getBlockByNumber"
 	fullBlock := model.FullBlock{}
 	// eth_getBlockByNumber 的第二个参数：
 	// 若是 true 则返回完整的区块信息，若为false 则 transaction 部分只返回交易哈希数组
-	err := r.client.client.Call(&fullBlock, methodName, number, true)
+	err := r.client.CallContext(ctx, &fullBlock, methodName, number, true)
 	if err != nil {
 		return nil, fmt.Errorf("get block info failed! %s", err.Error())
 	}
@@ -216,13 +490,55 @@ func (r *ETHRPCRequester) GetBlockInfoByNumber(blockNumber *big.Int) (*model.Ful
 	return &fullBlock, nil
 }
 
-// 根据区块哈希值获取区块信息
+// GetBlockInfosByNumber 根据区块号数组批量获取区块信息，一次批量请求完成
+// 任何一个区块获取失败或者为空都返回错误，结果的顺序和 blockNumbers 一致
+func (r *ETHRPCRequester) GetBlockInfosByNumber(blockNumbers []*big.Int) ([]*model.FullBlock, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetBlockInfosByNumberContext(ctx, blockNumbers)
+}
+
+// GetBlockInfosByNumberContext 根据区块号数组批量获取区块信息
+func (r *ETHRPCRequester) GetBlockInfosByNumberContext(ctx context.Context, blockNumbers []*big.Int) ([]*model.FullBlock, error) {
+	results := []*model.FullBlock{}
+	requesters := []rpc.BatchElem{}
+	for _, blockNumber := range blockNumbers {
+		result := model.FullBlock{}
+		requesters = append(requesters, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{fmt.Sprintf("%#x", blockNumber), true},
+			Result: &result,
+		})
+		results = append(results, &result)
+	}
+	if err := r.client.BatchCallContext(ctx, requesters); err != nil {
+		return nil, fmt.Errorf("get block infos failed! %s", err.Error())
+	}
+	for index, requester := range requesters {
+		if requester.Error != nil {
+			return nil, fmt.Errorf("get block info failed! %s", requester.Error.Error())
+		}
+		if results[index].Number == "" {
+			return nil, fmt.Errorf("block info is empty %s", blockNumbers[index].String())
+		}
+	}
+	return results, nil
+}
+
+// GetBlockInfoByHash 根据区块哈希值获取区块信息
 func (r *ETHRPCRequester) GetBlockInfoByHash(blockHash string) (*model.FullBlock, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetBlockInfoByHashContext(ctx, blockHash)
+}
+
+// GetBlockInfoByHashContext 根据区块哈希值获取区块信息
+func (r *ETHRPCRequester) GetBlockInfoByHashContext(ctx context.Context, blockHash string) (*model.FullBlock, error) {
 	methodName := "eth_getBlockByHash"
 	fullBlock := model.FullBlock{}
 	// eth_getBlockByHash 的第二个参数：
 	// 若为true则返回完整的区块信息，为false则 transaction 部分只返回交易哈希值数组
-	err := r.client.client.Call(&fullBlock, methodName, blockHash, true)
+	err := r.client.CallContext(ctx, &fullBlock, methodName, blockHash, true)
 	if err != nil {
 		return nil, fmt.Errorf("get block info failed! %s", err.Error())
 	}
@@ -232,17 +548,95 @@ func (r *ETHRPCRequester) GetBlockInfoByHash(blockHash string) (*model.FullBlock
 	return &fullBlock, nil
 }
 
-// 使用 eth_call 调用智能合约的函数
-// 第一个参数是接受结果的结构体，第二个参数是 eth_call 参数集合结构体
+// ETHCall 使用 eth_call 调用智能合约的函数
 func (r *ETHRPCRequester) ETHCall(result interface{}, arg model.CallArg) error {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.ETHCallContext(ctx, result, arg)
+}
+
+// ETHCallContext 使用 eth_call 调用智能合约的函数
+// 第一个参数是接受结果的结构体，第二个参数是 eth_call 参数集合结构体
+func (r *ETHRPCRequester) ETHCallContext(ctx context.Context, result interface{}, arg model.CallArg) error {
+	return r.ETHCallAtContext(ctx, result, arg, LatestBlock)
+}
+
+// ETHCallAt 在区块 block 的状态下使用 eth_call 调用智能合约的函数
+func (r *ETHRPCRequester) ETHCallAt(result interface{}, arg model.CallArg, block BlockTag) error {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.ETHCallAtContext(ctx, result, arg, block)
+}
+
+// ETHCallAtContext 在区块 block 的状态下使用 eth_call 调用智能合约的函数
+func (r *ETHRPCRequester) ETHCallAtContext(ctx context.Context, result interface{}, arg model.CallArg, block BlockTag) error {
 	methodName := "eth_call"
-	err := r.client.client.Call(result, methodName, arg, "latest")
+	err := r.client.CallContext(ctx, result, methodName, arg, block)
 	if err != nil {
 		return fmt.Errorf("eth_call failed! %s", err.Error())
 	}
 	return nil
 }
 
+// GetLogs 根据过滤条件获取合约事件日志
+// 节点因为结果太多拒绝查询时，会自动把区块范围拆分成两半分别查询
+func (r *ETHRPCRequester) GetLogs(filter model.LogFilter) ([]model.Log, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetLogsContext(ctx, filter)
+}
+
+// GetLogsContext 根据过滤条件获取合约事件日志
+func (r *ETHRPCRequester) GetLogsContext(ctx context.Context, filter model.LogFilter) ([]model.Log, error) {
+	methodName := "eth_getLogs"
+	logs := []model.Log{}
+	err := r.client.CallContext(ctx, &logs, methodName, filter)
+	if err == nil {
+		return logs, nil
+	}
+	if !isTooManyLogs(err) || filter.BlockHash != "" {
+		return nil, fmt.Errorf("获取事件日志失败！ %s", err.Error())
+	}
+	// 结果太多，把区块范围拆成两半。范围的两端需要是确定的区块号
+	if filter.ToBlock == nil {
+		latest, err := r.GetLatestBlockNumberContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		filter.ToBlock = latest
+	}
+	if filter.FromBlock == nil {
+		filter.FromBlock = new(big.Int).Set(filter.ToBlock)
+	}
+	if filter.FromBlock.Cmp(filter.ToBlock) >= 0 {
+		// 只剩一个区块，没办法再拆分了
+		return nil, fmt.Errorf("获取事件日志失败！ %s", err.Error())
+	}
+	middle := new(big.Int).Add(filter.FromBlock, filter.ToBlock)
+	middle.Rsh(middle, 1)
+	left, right := filter, filter
+	left.ToBlock = middle
+	right.FromBlock = new(big.Int).Add(middle, big.NewInt(1))
+	leftLogs, err := r.GetLogsContext(ctx, left)
+	if err != nil {
+		return nil, err
+	}
+	rightLogs, err := r.GetLogsContext(ctx, right)
+	if err != nil {
+		return nil, err
+	}
+	return append(leftLogs, rightLogs...), nil
+}
+
+// 判断节点是否因为结果太多而拒绝了 eth_getLogs 查询
+// 例如 geth 和 infura 的 "query returned more than 10000 results"
+func isTooManyLogs(err error) bool {
+	errInfo := strings.ToLower(err.Error())
+	return strings.Contains(errInfo, "query returned more than") ||
+		strings.Contains(errInfo, "response size exceeded") ||
+		strings.Contains(errInfo, "exceed maximum block range")
+}
+
 // 创建以太坊钱包
 func (r *ETHRPCRequester) CreateETHWallet(password string) (string, error) {
 	if password == "" {
@@ -262,39 +656,144 @@ func (r *ETHRPCRequester) CreateETHWallet(password string) (string, error) {
 	return wallet.Address.String(), nil
 }
 
-// 发送交易，根据传入 transaction 的不同变量设置，达到发送不同种类的交易
+// SendTransaction 发送交易，根据传入 transaction 的不同变量设置，达到发送不同种类的交易
 func (r *ETHRPCRequester) SendTransaction(address string, transaction *types.Transaction) (string, error) {
-	// 对交易数据进行签名
-	signTx, err := tool.SignETHTransaction(address, transaction)
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SendTransactionContext(ctx, address, transaction)
+}
+
+// SendTransactionContext 发送交易，根据传入 transaction 的不同变量设置，达到发送不同种类的交易
+// 交易的 nonce 由调用者设置，不经过 nonce 管理器的预留
+func (r *ETHRPCRequester) SendTransactionContext(ctx context.Context, address string, transaction *types.Transaction) (string, error) {
+	txHash, sendErr := r.signAndSend(ctx, address, transaction)
+	if sendErr != nil && txHash == "" {
+		return "", sendErr
+	}
+	// 交易使用了还没有分配过的 nonce，之后从它的下一个开始分配
+	// 交易已经广播成功，或者可能已经被节点收到，更新 nonce 失败时同时返回交易哈希值和错误
+	next, err := r.nonceManager.GetNonce(address)
+	if err != nil {
+		return txHash, err
+	}
+	if next == nil || transaction.Nonce() >= next.Uint64() {
+		if err := r.nonceManager.SetNonce(address, new(big.Int).SetUint64(transaction.Nonce()+1)); err != nil {
+			return txHash, err
+		}
+	}
+	return txHash, sendErr // 返回交易hash
+}
+
+// 使用 nonce 管理器预留的 nonce 构建并发送交易
+// 广播成功后确认 nonce，签名失败或者节点明确拒绝交易时归还 nonce，由下一笔交易填补
+// 确认或者归还 nonce 失败时同时返回交易哈希值和错误
+func (r *ETHRPCRequester) sendWithNonce(ctx context.Context, address string, build func(nonce uint64) *types.Transaction) (string, error) {
+	nonce, generation, err := r.reserveNonce(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	txHash, err := r.signAndSend(ctx, address, build(nonce))
+	if err != nil && txHash == "" && !errors.Is(err, ErrNonceUsed) {
+		if releaseErr := r.nonceManager.Release(address, nonce, generation); releaseErr != nil {
+			return "", fmt.Errorf("%w，归还 nonce 失败 %s", err, releaseErr.Error())
+		}
+		return "", err
+	}
+	// 广播成功、nonce 已经被其它交易使用、或者节点可能已经收到交易，都不能再分配出去
+	// 广播成功但是保存追踪交易失败时同样确认 nonce
+	if commitErr := r.nonceManager.Commit(address, nonce, generation); commitErr != nil {
+		if err != nil {
+			return txHash, fmt.Errorf("%w，确认 nonce 失败 %s", err, commitErr.Error())
+		}
+		return txHash, fmt.Errorf("确认 nonce 失败 %w", commitErr)
+	}
+	return txHash, err
+}
+
+// 对交易签名并广播，同一笔交易已经在节点交易池中时视为成功
+// nonce 已经被其它交易使用时返回的错误包含 ErrNonceUsed，
+// 节点没有返回 json-rpc 错误的失败，例如连接断开或者超时，同时返回交易哈希值和包含 ErrSendUncertain 的错误，
+// 保存追踪交易失败时同时返回交易哈希值和包含 ErrTxTrackFailed 的错误
+// 返回的交易哈希值不为空就说明交易已经或者可能已经被节点收到
+func (r *ETHRPCRequester) signAndSend(ctx context.Context, address string, transaction *types.Transaction) (string, error) {
+	// 对交易数据进行签名，chain id 和配置的不一致时拒绝签名
+	chainID, err := r.ChainIdContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("签名失败！ %w", err)
+	}
+	signer, err := r.Signer(address)
+	if err != nil {
+		return "", fmt.Errorf("签名失败！ %s", err.Error())
+	}
+	signTx, err := signer.SignTx(transaction, chainID)
 	if err != nil {
 		return "", fmt.Errorf("签名失败！ %s", err.Error())
 	}
-	// rlp 序列化
-	txRlpData, err := rlp.EncodeToBytes(signTx)
+	// 序列化，普通交易是 rlp 编码，带类型的交易是类型字节加上 rlp 编码
+	txRlpData, err := signTx.MarshalBinary()
 	if err != nil {
 		return "", fmt.Errorf("rlp 序列号失败！ %s", err.Error())
 	}
 	// 下面调用以太坊的 rpc 接口
 	txHash := ""
+	var sendErr error
 	methodName := "eth_sendRawTransaction"
-	err = r.client.client.Call(&txHash, methodName, common.Bytes2Hex(txRlpData))
+	err = r.client.CallContext(ctx, &txHash, methodName, hexutil.Encode(txRlpData))
 	if err != nil {
-		return "", fmt.Errorf("发送交易失败！ %s", err.Error())
+		message := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(message, "already known") || strings.Contains(message, "known transaction"):
+			txHash = signTx.Hash().Hex()
+		case strings.Contains(message, "nonce too low") || strings.Contains(message, "replacement transaction underpriced"):
+			return "", fmt.Errorf("%w: 发送交易失败！ %s", ErrNonceUsed, err.Error())
+		default:
+			var rpcErr rpc.Error
+			if errors.As(err, &rpcErr) {
+				return "", fmt.Errorf("发送交易失败！ %s", err.Error())
+			}
+			// 交易可能已经被节点收到，同样需要追踪，节点没有收到时会被标记为丢弃
+			txHash = signTx.Hash().Hex()
+			sendErr = fmt.Errorf("%w: 发送交易失败！ %s", ErrSendUncertain, err.Error())
+		}
 	}
-	oldNonce := r.nonceManager.GetNonce(address)
-	if oldNonce == nil {
-		r.nonceManager.SetNonce(address, new(big.Int).SetUint64(transaction.Nonce()))
+	if r.tracker != nil {
+		// 交易已经广播出去了，或者可能已经被节点收到，保存失败时之后无法加速或者取消，需要告诉调用者
+		if err := r.tracker.track(address, signTx); err != nil {
+			if sendErr != nil {
+				sendErr = fmt.Errorf("%w，保存追踪交易失败 %s", sendErr, err.Error())
+			} else {
+				sendErr = fmt.Errorf("%w: %s", ErrTxTrackFailed, err.Error())
+			}
+		}
 	}
-	r.nonceManager.PlusNonce(address) // 成功后，当前用户内存的 nonce 值加 1
-	return txHash, nil                // 返回交易hash
+	return txHash, sendErr
 }
 
-// 获取地址的 noce 值
+// GetNonce 获取地址的 noce 值
 func (r *ETHRPCRequester) GetNonce(address string) (uint64, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetNonceContext(ctx, address)
+}
+
+// GetNonceContext 获取地址的 noce 值
+func (r *ETHRPCRequester) GetNonceContext(ctx context.Context, address string) (uint64, error) {
+	// 因为我们要查询最新的，根据基于 eth_getTransactionCount 情况下的区块号关系，选取 pending
+	return r.GetNonceAtContext(ctx, address, PendingBlock)
+}
+
+// GetNonceAt 获取地址在区块 block 时已经使用的 nonce 数量，LatestBlock 只包含已经打包的交易
+func (r *ETHRPCRequester) GetNonceAt(address string, block BlockTag) (uint64, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.GetNonceAtContext(ctx, address, block)
+}
+
+// GetNonceAtContext 获取地址在区块 block 时已经使用的 nonce 数量，LatestBlock 只包含已经打包的交易
+func (r *ETHRPCRequester) GetNonceAtContext(ctx context.Context, address string, block BlockTag) (uint64, error) {
 	methodName := "eth_getTransactionCount" // 指定接口名称
 	nonce := ""
-	// 因为我们要查询最新的，根据基于 eth_getTransactionCount 情况下的区块号关系，选取 pending
-	err := r.client.client.Call(&nonce, methodName, address, "pending")
+	err := r.client.CallContext(ctx, &nonce, methodName, address, block)
 	if err != nil {
 		return 0, fmt.Errorf("发送交易失败！ %s", err.Error())
 	}
@@ -302,15 +801,52 @@ func (r *ETHRPCRequester) GetNonce(address string) (uint64, error) {
 	return n.Uint64(), nil
 }
 
-// 发送 ETH 交易，或称转账 ETH
-// 参数分别是交易发起地址、交易接收地址、ETH数量、燃料费设置
+// SetNonceManager 设置 nonce 管理器，多个实例共用一个钱包时传入使用 SQLNonceStore 的管理器
+func (r *ETHRPCRequester) SetNonceManager(nonceManager *NonceManager) {
+	r.nonceManager = nonceManager
+}
+
+// SetTransactionTracker 设置交易追踪器，之后广播成功的交易都会被保存和追踪
+func (r *ETHRPCRequester) SetTransactionTracker(tracker *TransactionTracker) {
+	r.tracker = tracker
+}
+
+// 为地址预留一个 nonce，地址在这次启动后第一次使用以及距离上次校正超过校正间隔时，
+// 先和节点上 pending 的 nonce 校正，校正时清理已经上链的 nonce 记录
+// 返回的 generation 在确认或者归还 nonce 时使用
+func (r *ETHRPCRequester) reserveNonce(ctx context.Context, address string) (uint64, uint64, error) {
+	if !r.nonceManager.IsReconciled(address) {
+		pending, err := r.GetNonceContext(ctx, address)
+		if err != nil {
+			return 0, 0, fmt.Errorf("获取 nonce 失败 %s", err.Error())
+		}
+		if err := r.nonceManager.Reconcile(address, pending); err != nil {
+			return 0, 0, fmt.Errorf("校正 nonce 失败 %s", err.Error())
+		}
+	}
+	nonce, generation, err := r.nonceManager.Reserve(address)
+	if err != nil {
+		return 0, 0, fmt.Errorf("预留 nonce 失败 %s", err.Error())
+	}
+	return nonce, generation, nil
+}
+
+// SendETHTransaction 发送 ETH 交易，或称转账 ETH
 func (r *ETHRPCRequester) SendETHTransaction(fromStr, toStr, valueStr string, gasLimit, gasPrice uint64) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SendETHTransactionContext(ctx, fromStr, toStr, valueStr, gasLimit, gasPrice)
+}
+
+// SendETHTransactionContext 发送 ETH 交易，或称转账 ETH
+// 参数分别是交易发起地址、交易接收地址、ETH数量、燃料费设置
+// gasLimit 为 0 时使用 eth_estimateGas 自动估算，gasPrice 为 0 时使用 eth_gasPrice
+func (r *ETHRPCRequester) SendETHTransactionContext(ctx context.Context, fromStr, toStr, valueStr string, gasLimit, gasPrice uint64) (string, error) {
 	if !common.IsHexAddress(fromStr) || !common.IsHexAddress(toStr) {
 		return "", errors.New("invalid address")
 	}
 
 	to := common.HexToAddress(toStr) // 将字符串类型转为 address 类型
-	gasPrice_ := new(big.Int).SetUint64(gasPrice)
 
 	// value 乘上 10^decimal，得出真实的转账值，ETH 单位精确到小数点后 18 位
 	realV := tool.GetRealDecimalValue(valueStr, 18)
@@ -319,68 +855,143 @@ func (r *ETHRPCRequester) SendETHTransaction(fromStr, toStr, valueStr string, ga
 	}
 	amount, _ := new(big.Int).SetString(realV, 10)
 
-	// 获取 nonce
-	nonce := r.nonceManager.GetNonce(fromStr)
-	if nonce == nil {
-		// nonce 不存在，开始访问节点获取
-		n, err := r.GetNonce(fromStr)
-		if err != nil {
-			return "", fmt.Errorf("获取 nonce 失败 %s", err.Error())
-		}
-		nonce = new(big.Int).SetUint64(n)
-		r.nonceManager.SetNonce(fromStr, nonce) // 为当前的地址设置 nonce
-	}
 	// 构建 data，因为 eth 是交易转账类型，所有 data 是空的，我们设置空字符串即可
 	data := []byte("")
+	return r.sendLegacyTransaction(ctx, fromStr, to, amount, gasLimit, gasPrice, data)
+}
 
-	// 构建交易结构体
-	transaction := types.NewTransaction(
-		nonce.Uint64(),
-		to,
-		amount,
-		gasLimit,
-		gasPrice_,
-		data)
-	return r.SendTransaction(fromStr, transaction)
+// SendERC20Transaction 发送 ERC20 代币交易，或称转账 ERC20 代币
+func (r *ETHRPCRequester) SendERC20Transaction(fromStr, contact, receiver, valueStr string, gasLimit, gasPrice uint64, decimal int) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SendERC20TransactionContext(ctx, fromStr, contact, receiver, valueStr, gasLimit, gasPrice, decimal)
 }
 
-// 发送 ERC20 代币交易，或称转账 ERC20 代币
+// SendERC20TransactionContext 发送 ERC20 代币交易，或称转账 ERC20 代币
 // 参数分别是
 // 交易的发起地址、代币的合约地址、交易接受地址、代币数量、燃料费设置、代币的 decimal 值
-func (r *ETHRPCRequester) SendERC20Transaction(fromStr, contact, receiver, valueStr string, gasLimit, gasPrice uint64, decimal int) (string, error) {
+// gasLimit 为 0 时使用 eth_estimateGas 自动估算，gasPrice 为 0 时使用 eth_gasPrice
+// decimal 为 DecimalAuto 时从代币信息注册表获取，代币数量的小数位数超过 decimal 时返回错误
+func (r *ETHRPCRequester) SendERC20TransactionContext(ctx context.Context, fromStr, contact, receiver, valueStr string, gasLimit, gasPrice uint64, decimal int) (string, error) {
 	if !common.IsHexAddress(fromStr) || !common.IsHexAddress(contact) || !common.IsHexAddress(receiver) {
 		return "", errors.New("invalid address")
 	}
 
+	decimal, err := r.resolveDecimal(ctx, contact, decimal)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := new(big.Int).SetString(tool.GetRealDecimalValue(valueStr, decimal), 10); !ok {
+		return "", errors.New("invalid value")
+	}
+
 	to := common.HexToAddress(contact) // 将合约 contact 字符串类型转为 address 类型
-	gasPrice_ := new(big.Int).SetUint64(gasPrice)
 
 	// 结构体中的 value 字段为 0
 	amount := new(big.Int).SetInt64(0)
 
-	// 获取 nonce
-	nonce := r.nonceManager.GetNonce(fromStr)
-	if nonce == nil {
-		// nonce 不存在，开始访问节点获取
-		n, err := r.GetNonce(fromStr)
-		if err != nil {
-			return "", fmt.Errorf("获取 nonce 失败 %s", err.Error())
+	// 构建 data，真实的 value 转账数值由 data 携带
+	data := tool.BuildERC20TransferData(valueStr, receiver, decimal)
+	dataBytes := common.FromHex(data) // 使用以太坊提供的函数将16进制转为字节
+
+	return r.sendLegacyTransaction(ctx, fromStr, to, amount, gasLimit, gasPrice, dataBytes)
+}
+
+// 构建并发送普通类型的交易，燃料在签名之前估算，估算失败时不会占用 nonce
+func (r *ETHRPCRequester) sendLegacyTransaction(ctx context.Context, fromStr string, to common.Address, amount *big.Int, gasLimit, gasPrice uint64, data []byte) (string, error) {
+	gasLimit, err := r.fillGasLimit(ctx, fromStr, &to, amount, data, gasLimit)
+	if err != nil {
+		return "", err
+	}
+	gasPrice_ := new(big.Int).SetUint64(gasPrice)
+	if gasPrice == 0 {
+		if gasPrice_, err = r.GetGasPriceContext(ctx); err != nil {
+			return "", err
 		}
-		nonce = new(big.Int).SetUint64(n)
-		r.nonceManager.SetNonce(fromStr, nonce) // 为当前的地址设置 nonce
 	}
+	// 构建交易结构体，nonce 由 nonce 管理器预留
+	return r.sendWithNonce(ctx, fromStr, func(nonce uint64) *types.Transaction {
+		return types.NewTransaction(
+			nonce,
+			to,
+			amount,
+			gasLimit,
+			gasPrice_,
+			data)
+	})
+}
+
+// SendETHTransactionWithFee 发送 EIP-1559 类型的 ETH 交易，燃料费由 fee 决定，gasLimit 为 0 时自动估算
+func (r *ETHRPCRequester) SendETHTransactionWithFee(fromStr, toStr, valueStr string, gasLimit uint64, fee FeeOption) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SendETHTransactionWithFeeContext(ctx, fromStr, toStr, valueStr, gasLimit, fee)
+}
 
+// SendETHTransactionWithFeeContext 发送 EIP-1559 类型的 ETH 交易，燃料费由 fee 决定
+func (r *ETHRPCRequester) SendETHTransactionWithFeeContext(ctx context.Context, fromStr, toStr, valueStr string, gasLimit uint64, fee FeeOption) (string, error) {
+	if !common.IsHexAddress(fromStr) || !common.IsHexAddress(toStr) {
+		return "", errors.New("invalid address")
+	}
+	to := common.HexToAddress(toStr)
+	// value 乘上 10^decimal，得出真实的转账值，ETH 单位精确到小数点后 18 位
+	realV := tool.GetRealDecimalValue(valueStr, 18)
+	if realV == "" {
+		return "", errors.New("invalid value")
+	}
+	amount, _ := new(big.Int).SetString(realV, 10)
+	return r.sendDynamicFeeTransaction(ctx, fromStr, &to, amount, gasLimit, nil, fee)
+}
+
+// SendERC20TransactionWithFee 发送 EIP-1559 类型的 ERC20 代币交易，燃料费由 fee 决定，gasLimit 为 0 时自动估算
+func (r *ETHRPCRequester) SendERC20TransactionWithFee(fromStr, contact, receiver, valueStr string, gasLimit uint64, decimal int, fee FeeOption) (string, error) {
+	ctx, cancel := r.defaultContext()
+	defer cancel()
+	return r.SendERC20TransactionWithFeeContext(ctx, fromStr, contact, receiver, valueStr, gasLimit, decimal, fee)
+}
+
+// SendERC20TransactionWithFeeContext 发送 EIP-1559 类型的 ERC20 代币交易，燃料费由 fee 决定
+func (r *ETHRPCRequester) SendERC20TransactionWithFeeContext(ctx context.Context, fromStr, contact, receiver, valueStr string, gasLimit uint64, decimal int, fee FeeOption) (string, error) {
+	if !common.IsHexAddress(fromStr) || !common.IsHexAddress(contact) || !common.IsHexAddress(receiver) {
+		return "", errors.New("invalid address")
+	}
+	decimal, err := r.resolveDecimal(ctx, contact, decimal)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := new(big.Int).SetString(tool.GetRealDecimalValue(valueStr, decimal), 10); !ok {
+		return "", errors.New("invalid value")
+	}
+	to := common.HexToAddress(contact)
 	// 构建 data，真实的 value 转账数值由 data 携带
-	data := tool.BuildERC20TransferData(valueStr, receiver, decimal)
-	dataBytes := common.FromHex(data) // 使用以太坊提供的函数将16进制转为字节
+	data := common.FromHex(tool.BuildERC20TransferData(valueStr, receiver, decimal))
+	return r.sendDynamicFeeTransaction(ctx, fromStr, &to, new(big.Int), gasLimit, data, fee)
+}
 
-	// 构建交易结构体
-	transaction := types.NewTransaction(
-		nonce.Uint64(),
-		to,
-		amount,
-		gasLimit,
-		gasPrice_,
-		dataBytes)
-	return r.SendTransaction(fromStr, transaction)
+// 构建并发送 EIP-1559 类型的交易
+func (r *ETHRPCRequester) sendDynamicFeeTransaction(ctx context.Context, fromStr string, to *common.Address, amount *big.Int, gasLimit uint64, data []byte, fee FeeOption) (string, error) {
+	chainID, err := r.ChainIdContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	gasLimit, err = r.fillGasLimit(ctx, fromStr, to, amount, data, gasLimit)
+	if err != nil {
+		return "", err
+	}
+	dynamicFee, err := r.dynamicFee(ctx, fee)
+	if err != nil {
+		return "", err
+	}
+	return r.sendWithNonce(ctx, fromStr, func(nonce uint64) *types.Transaction {
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: dynamicFee.MaxPriorityFeePerGas,
+			GasFeeCap: dynamicFee.MaxFeePerGas,
+			Gas:       gasLimit,
+			To:        to,
+			Value:     amount,
+			Data:      data,
+		})
+	})
 }
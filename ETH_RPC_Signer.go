@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"eth-relay/tool"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 按照地址保存的签名者，ETHRPCRequester 按值复制后仍然共用同一份
+type signerSet struct {
+	lock    sync.RWMutex
+	signers map[common.Address]tool.Signer
+}
+
+// AddSigner 添加发送交易时使用的签名者，同一个地址的签名者会被替换
+func (r *ETHRPCRequester) AddSigner(signer tool.Signer) {
+	r.signers.lock.Lock()
+	defer r.signers.lock.Unlock()
+	r.signers.signers[signer.Address()] = signer
+}
+
+// RemoveSigner 移除地址的签名者
+func (r *ETHRPCRequester) RemoveSigner(address string) {
+	r.signers.lock.Lock()
+	defer r.signers.lock.Unlock()
+	delete(r.signers.signers, common.HexToAddress(address))
+}
+
+// WithCaller 返回以 caller 作为调用者身份的请求者，它签名时把 caller 记录到 tool 包的审计日志中
+// 返回的请求者和原来的请求者共用节点池、nonce 管理器和签名者
+func (r *ETHRPCRequester) WithCaller(caller string) *ETHRPCRequester {
+	copied := *r
+	copied.caller = caller
+	return &copied
+}
+
+// Signer 返回地址的签名者，没有添加过时使用 tool 包中全局解锁的钱包
+// 返回的签名者每次签名都会连同请求者的调用者身份记录到审计日志中
+func (r *ETHRPCRequester) Signer(address string) (tool.Signer, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errors.New("invalid address")
+	}
+	r.signers.lock.RLock()
+	signer, ok := r.signers.signers[common.HexToAddress(address)]
+	r.signers.lock.RUnlock()
+	if !ok {
+		var err error
+		if signer, err = tool.UnlockedSigner(address); err != nil {
+			return nil, err
+		}
+	}
+	return tool.NewAuditSigner(signer, r.caller), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"eth-relay/dao"
+	"eth-relay/model"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// ErrBackfillStopped 代表回填过程中调用了 Stop，进度已经保存，下次可以继续
+var ErrBackfillStopped = errors.New("backfill stopped")
+
+// 一批区块的获取结果
+type backfillBatch struct {
+	blocks   []*model.FullBlock
+	receipts [][]*model.Receipt // 和 blocks 一一对应，不需要收据时为 nil
+	err      error
+}
+
+// SetBackfillConcurrency 设置回填时同时获取的批次数量，需要在 Backfill 之前调用
+func (scanner *BlockScanner) SetBackfillConcurrency(concurrency int) {
+	if concurrency > 0 {
+		scanner.backfillConcurrency = concurrency
+	}
+}
+
+// SetBackfillBatchSize 设置回填时每次 eth_getBlockByNumber 批量请求的区块数量，需要在 Backfill 之前调用
+func (scanner *BlockScanner) SetBackfillBatchSize(size int) {
+	if size > 0 {
+		scanner.backfillBatchSize = size
+	}
+}
+
+// StartFrom 从区块号 from 开始回填历史区块，追到最新区块后自动切换为实时遍历
+// 回填和实时遍历都在后台协程中进行，回填失败的原因会发送到 Errors 管道
+func (scanner *BlockScanner) StartFrom(from *big.Int) error {
+	scanner.lock.Lock()
+	go func() {
+		if err := scanner.Backfill(from, nil); err != nil {
+			if errors.Is(err, ErrBackfillStopped) {
+				scanner.log("finish block scanner!")
+				return
+			}
+			scanner.fatal(err)
+			return
+		}
+		// 回填保存的最后一个区块就是实时遍历的起点
+		if err := scanner.init(); err != nil {
+			scanner.fatal(err)
+			return
+		}
+		scanner.run()
+	}()
+	return nil
+}
+
+// Backfill 回填 [from, to] 范围内的历史区块，to 为 nil 时一直追到最新区块（减去确认数）
+// 区块按照批次并发获取，按照区块号顺序保存，回填进度保存在 backfill_checkpoint 表中，
+// 相同的 from 和 to 再次调用时从上一次中断的地方继续
+// 不能和 Start 同时对同一个遍历器调用
+func (scanner *BlockScanner) Backfill(from, to *big.Int) error {
+	checkpoint, err := scanner.loadCheckpoint(from, to)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Finished {
+		return nil
+	}
+	scanner.checkpoint = checkpoint
+	defer func() {
+		scanner.checkpoint = nil
+	}()
+	scanner.lastBlock = &dao.Block{} // 第一个回填的区块不进行分叉检查
+	next := big.NewInt(checkpoint.NextNumber)
+	for {
+		end := to
+		if end == nil {
+			// 最新区块在回填的过程中不断增长，每一轮都重新获取
+			latest, err := scanner.ethRequester.GetLatestBlockNumber()
+			if err != nil {
+				return err
+			}
+			end = latest.Sub(latest, new(big.Int).SetUint64(scanner.confirmations))
+		}
+		if next.Cmp(end) > 0 {
+			break // 已经追上
+		}
+		scanner.log("backfill blocks ==> ", next.String(), "-", end.String())
+		err := scanner.fetchBackfill(next, end, scanner.saveBackfillBlock)
+		if err == nil {
+			next = new(big.Int).Add(end, big.NewInt(1))
+			continue
+		}
+		if !scanner.fork {
+			return err
+		}
+		// 回填到了刚刚发生分叉的区块，从分叉点的下一个区块重新回填
+		scanner.fork = false
+		next = scanner.hexToTen(scanner.lastBlock.BlockNumber)
+		next.Add(next, big.NewInt(1))
+	}
+	checkpoint.Finished = true
+	checkpoint.UpdateTime = time.Now().Unix()
+	_, err = scanner.mysql.Db.ID(checkpoint.Id).Cols("finished", "update_time").Update(checkpoint)
+	return err
+}
+
+// 保存回填的区块，它的父区块在回填开始之前，不需要检查分叉
+func (scanner *BlockScanner) saveBackfillBlock(fullBlock *model.FullBlock, receipts []*model.Receipt) error {
+	if scanner.lastBlock.BlockHash == "" {
+		scanner.lastBlock = &dao.Block{
+			BlockHash:   fullBlock.ParentHash,
+			BlockNumber: new(big.Int).Sub(scanner.hexToTen(fullBlock.Number), big.NewInt(1)).String(),
+		}
+	}
+	return scanner.saveBlock(fullBlock, receipts)
+}
+
+// 并发获取 [from, to] 范围内的区块，按照区块号顺序交给 save 保存
+// 同时在请求中的批次不超过 backfillConcurrency 个，保存出错或者调用了 Stop 时不再获取新的批次
+func (scanner *BlockScanner) fetchBackfill(from, to *big.Int, save func(fullBlock *model.FullBlock, receipts []*model.Receipt) error) error {
+	quit := make(chan struct{})
+	defer close(quit)
+	// 每个批次的结果管道按照区块号顺序放入 results，保存时按顺序取出
+	// 正在保存的批次不占用 results 的容量，所以容量是 backfillConcurrency - 1
+	results := make(chan chan backfillBatch, scanner.backfillConcurrency-1)
+	go func() {
+		defer close(results)
+		size := big.NewInt(int64(scanner.backfillBatchSize))
+		for start := new(big.Int).Set(from); start.Cmp(to) <= 0; start = new(big.Int).Add(start, size) {
+			end := new(big.Int).Add(start, size)
+			end.Sub(end, big.NewInt(1))
+			if end.Cmp(to) > 0 {
+				end.Set(to)
+			}
+			result := make(chan backfillBatch, 1)
+			select {
+			case results <- result:
+			case <-quit:
+				return
+			}
+			go func(start, end *big.Int) {
+				result <- scanner.fetchBackfillBatch(start, end)
+			}(start, end)
+		}
+	}()
+	for result := range results {
+		select {
+		case <-scanner.stop: // 监听是否退出遍历
+			return ErrBackfillStopped
+		default:
+		}
+		batch := <-result
+		if batch.err != nil {
+			return batch.err
+		}
+		for index, fullBlock := range batch.blocks {
+			if err := save(fullBlock, batch.receipts[index]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 获取一个批次的区块和交易收据，失败时重试 3 次
+func (scanner *BlockScanner) fetchBackfillBatch(from, to *big.Int) backfillBatch {
+	numbers := []*big.Int{}
+	for number := new(big.Int).Set(from); number.Cmp(to) <= 0; number = new(big.Int).Add(number, big.NewInt(1)) {
+		numbers = append(numbers, number)
+	}
+	var err error
+	for retry := 0; retry < 3; retry++ {
+		if retry > 0 {
+			scanner.log("获取回填区块失败，重试一次....", from.String(), "-", to.String(), err.Error())
+			time.Sleep(time.Duration(retry) * time.Second)
+		}
+		var blocks []*model.FullBlock
+		blocks, err = scanner.ethRequester.GetBlockInfosByNumber(numbers)
+		if err != nil {
+			continue
+		}
+		batch := backfillBatch{blocks: blocks}
+		for _, fullBlock := range blocks {
+			var receipts []*model.Receipt
+			receipts, err = scanner.getBlockReceipts(fullBlock)
+			if err != nil {
+				break
+			}
+			batch.receipts = append(batch.receipts, receipts)
+		}
+		if err == nil {
+			return batch
+		}
+	}
+	return backfillBatch{err: fmt.Errorf("回填区块 %s - %s 失败 %s", from.String(), to.String(), err.Error())}
+}
+
+// 获取回填进度，不存在时新建一条
+func (scanner *BlockScanner) loadCheckpoint(from, to *big.Int) (*dao.BackfillCheckpoint, error) {
+	toNumber := int64(-1)
+	if to != nil {
+		toNumber = to.Int64()
+	}
+	checkpoint := dao.BackfillCheckpoint{}
+	has, err := scanner.mysql.Db.
+		Where("from_number = ? and to_number = ?", from.Int64(), toNumber).
+		Get(&checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("查询回填进度失败 %s", err.Error())
+	}
+	if has {
+		return &checkpoint, nil
+	}
+	checkpoint = dao.BackfillCheckpoint{
+		FromNumber: from.Int64(),
+		ToNumber:   toNumber,
+		NextNumber: from.Int64(),
+		UpdateTime: time.Now().Unix(),
+	}
+	if _, err := scanner.mysql.Db.Insert(&checkpoint); err != nil {
+		return nil, fmt.Errorf("保存回填进度失败 %s", err.Error())
+	}
+	return &checkpoint, nil
+}
+
+// 在保存区块的事务中更新回填进度
+func (scanner *BlockScanner) updateCheckpoint(session *xorm.Session, nextNumber int64) error {
+	scanner.checkpoint.NextNumber = nextNumber
+	scanner.checkpoint.UpdateTime = time.Now().Unix()
+	_, err := session.ID(scanner.checkpoint.Id).Cols("next_number", "update_time").Update(scanner.checkpoint)
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"eth-relay/dao"
+	"eth-relay/model"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/go-xorm/xorm"
+)
+
+// BlockHandler 是区块遍历器的自定义处理器接口，使用 RegisterHandler 注册到遍历器中
+// 所有函数都运行在保存区块的同一个数据库事务 session 中，
+// 处理器使用 session 写入的数据和区块一起提交，任意一个函数返回错误时整个区块回滚
+type BlockHandler interface {
+	// OnBlock 在区块和交易保存之后调用
+	OnBlock(session *xorm.Session, block *model.FullBlock) error
+	// OnTransaction 对区块中的每笔交易调用，遍历器没有获取收据时 receipt 为 nil
+	OnTransaction(session *xorm.Session, block *model.FullBlock, transaction *dao.Transaction, receipt *model.Receipt) error
+	// OnReorg 在检测到分叉时调用，forkBlock 是分叉点，
+	// 它之后直到 currentBlock 区块号的记录都已经被标记为分叉
+	OnReorg(session *xorm.Session, forkBlock *dao.Block, currentBlock *dao.Block) error
+}
+
+// ConfirmationHandler 是可选的处理器接口，处理器同时实现了它时，
+// 遍历器会在区块的不同安全级别分别通知，下游可以自己选择需要的安全级别
+type ConfirmationHandler interface {
+	// OnPending 在新区块出现、确认数还不够时调用，confirmations 是它之后已经生成的区块数量
+	// 区块仍然可能因为分叉被撤销，只能用来展示，不能用来入账
+	OnPending(block *model.FullBlock, confirmations uint64) error
+	// OnConfirmed 在区块达到确认数被保存时调用，和区块的保存在同一个数据库事务中
+	OnConfirmed(session *xorm.Session, block *model.FullBlock) error
+}
+
+// BaseBlockHandler 是什么都不做的处理器，嵌入到自定义处理器中就只需要实现关心的函数
+type BaseBlockHandler struct{}
+
+func (BaseBlockHandler) OnBlock(session *xorm.Session, block *model.FullBlock) error {
+	return nil
+}
+
+func (BaseBlockHandler) OnTransaction(session *xorm.Session, block *model.FullBlock, transaction *dao.Transaction, receipt *model.Receipt) error {
+	return nil
+}
+
+func (BaseBlockHandler) OnReorg(session *xorm.Session, forkBlock *dao.Block, currentBlock *dao.Block) error {
+	return nil
+}
+
+// LogBlockHandler 把每个区块的前几条交易哈希打印出来，用来演示自定义处理
+type LogBlockHandler struct {
+	BaseBlockHandler
+	MaxTransactions int // 每个区块最多打印的交易数量，0 代表全部打印
+}
+
+func (h *LogBlockHandler) OnTransaction(session *xorm.Session, block *model.FullBlock, transaction *dao.Transaction, receipt *model.Receipt) error {
+	index, _ := new(big.Int).SetString(strings.TrimPrefix(transaction.TransactionIndex, "0x"), 16)
+	if h.MaxTransactions > 0 && index != nil && index.Int64() >= int64(h.MaxTransactions) {
+		return nil
+	}
+	// 对于每条 tx，我们是完全可以进一步从里面提取信息的！
+	fmt.Println("tx hash ==> ", transaction.Hash)
+	return nil
+}
+
+func (h *LogBlockHandler) OnReorg(session *xorm.Session, forkBlock *dao.Block, currentBlock *dao.Block) error {
+	fmt.Println("reorg ==> ", "from: ", forkBlock.BlockNumber, "to: ", currentBlock.BlockNumber)
+	return nil
+}
+
+// RegisterHandler 注册区块处理器，需要在 Start 之前调用
+func (scanner *BlockScanner) RegisterHandler(handler BlockHandler) {
+	scanner.handlers = append(scanner.handlers, handler)
+}
+
+// 依次调用处理器处理区块和区块中的每笔交易
+func (scanner *BlockScanner) runHandlers(session *xorm.Session, block *model.FullBlock, receipts []*model.Receipt) error {
+	if len(scanner.handlers) == 0 {
+		return nil
+	}
+	// 收据和交易按照哈希值对应起来
+	receiptMap := map[string]*model.Receipt{}
+	for _, receipt := range receipts {
+		receiptMap[receipt.TransactionHash] = receipt
+	}
+	for _, handler := range scanner.handlers {
+		if err := handler.OnBlock(session, block); err != nil {
+			return err
+		}
+		for i := range block.Transactions {
+			transaction := &block.Transactions[i]
+			if err := handler.OnTransaction(session, block, transaction, receiptMap[transaction.Hash]); err != nil {
+				return err
+			}
+		}
+		// 被保存的区块都已经达到了确认数
+		if confirmationHandler, ok := handler.(ConfirmationHandler); ok {
+			if err := confirmationHandler.OnConfirmed(session, block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 找出同时实现了 ConfirmationHandler 的处理器
+func (scanner *BlockScanner) confirmationHandlers() []ConfirmationHandler {
+	handlers := []ConfirmationHandler{}
+	for _, handler := range scanner.handlers {
+		if confirmationHandler, ok := handler.(ConfirmationHandler); ok {
+			handlers = append(handlers, confirmationHandler)
+		}
+	}
+	return handlers
+}
@@ -10,8 +10,13 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/go-xorm/xorm"
 )
 
+// ErrForkResolution 代表分叉处理失败，遍历器会停止并把错误发送到 Errors 管道
+var ErrForkResolution = errors.New("fork resolution failed")
+
 // 区块遍历器
 type BlockScanner struct {
 	ethRequester ETHRPCRequester    // 以太坊 rpc 请求者对象
@@ -20,7 +25,25 @@ type BlockScanner struct {
 	lastNumber   *big.Int           // 上一次区块的区块号
 	fork         bool               // 区块分叉标记位
 	stop         chan bool          // 用来控制是否停止遍历的管道
+	errs         chan error         // 致命错误的管道，遍历器停止时会把原因发送到这里
 	lock         sync.Mutex         // 互斥锁，控制并发
+	saveReceipts bool               // 是否同时保存交易收据
+	indexERC20   bool               // 是否解析并保存 ERC20 转账记录
+	handlers     []BlockHandler     // 注册的区块处理器，按照注册的顺序调用
+
+	confirmations uint64   // 确认数，只有在最新区块号 - 区块号 >= confirmations 时才保存区块
+	pendingNumber *big.Int // 已经通知过 OnPending 的最高区块号
+
+	backfillConcurrency int                     // 回填时同时获取区块的批次数量
+	backfillBatchSize   int                     // 回填时每次批量请求的区块数量
+	checkpoint          *dao.BackfillCheckpoint // 回填进度，只在回填时不为空
+
+	headLock   sync.Mutex    // 保护订阅获取到的最新区块号
+	head       *big.Int      // 订阅获取到的最新区块号
+	subscribed bool          // 是否正在通过 newHeads 订阅获取新区块
+	newHead    chan struct{} // 收到新区块头时唤醒等待中的遍历
+
+	resubscribeInterval time.Duration // 重新订阅或者重新检查节点是否支持订阅的间隔
 }
 
 // 实例化 区块遍历器
@@ -30,79 +53,100 @@ func NewBlockScanner(requester ETHRPCRequester, mysql dao.MySQLConnector) *Block
 		mysql:        mysql,
 		lastBlock:    &dao.Block{},
 		fork:         false,
-		stop:         make(chan bool),
+		stop:         make(chan bool, 1),
+		errs:         make(chan error, 1),
 		lock:         sync.Mutex{},
+		newHead:      make(chan struct{}, 1),
+
+		backfillConcurrency: 4,
+		backfillBatchSize:   20,
+		resubscribeInterval: resubscribeInterval,
+	}
+}
+
+// SetSaveReceipts 设置是否在保存交易的同时保存交易收据，需要在 Start 之前调用
+// 开启后每个区块会多一次批量的 eth_getTransactionReceipt 请求
+func (scanner *BlockScanner) SetSaveReceipts(save bool) {
+	scanner.saveReceipts = save
+}
+
+// SetIndexERC20Transfers 设置是否从交易收据中解析 ERC20 Transfer 事件并保存到 token_transfer 表，
+// 需要在 Start 之前调用
+func (scanner *BlockScanner) SetIndexERC20Transfers(index bool) {
+	scanner.indexERC20 = index
+}
+
+// SetConfirmations 设置确认数 n，需要在 Start 之前调用
+// 遍历器只保存最新区块号 - n 及之前的区块，更新的区块只通知 ConfirmationHandler 的 OnPending
+func (scanner *BlockScanner) SetConfirmations(n uint64) {
+	scanner.confirmations = n
+}
+
+// Errors 返回致命错误的管道，从这里收到错误说明遍历器已经停止
+func (scanner *BlockScanner) Errors() <-chan error {
+	return scanner.errs
+}
+
+// 报告致命错误，没有人接收时不会阻塞
+func (scanner *BlockScanner) fatal(err error) {
+	scanner.log("block scanner stopped:", err.Error())
+	select {
+	case scanner.errs <- err:
+	default:
 	}
 }
 
 // 整个区块扫码的启动函数
 func (scanner *BlockScanner) Start() error {
 	scanner.lock.Lock()
-	init := func() error {
-		// 寻找出上一次成功遍历的区块
-		_, err := scanner.mysql.Db.
-			Desc("create_time").
-			Where("fork = ?", false).
-			Get(scanner.lastBlock)
-		if err != nil {
+	if err := scanner.init(); err != nil {
+		return err
+	}
+	// 启动一个协程来遍历区块
+	go scanner.run()
+	return nil
+}
+
+// 遍历区块的循环，直到调用了 Stop 或者出现致命错误
+func (scanner *BlockScanner) run() {
+	// 节点支持订阅时由新区块头驱动遍历，订阅断开时回到轮询
+	quit := make(chan struct{})
+	defer close(quit)
+	go scanner.subscribeHeads(quit)
+	init := scanner.init
+	execute := func() error {
+		if err := scanner.scan(); nil != err {
 			return err
 		}
-		if scanner.lastBlock.BlockHash == "" {
-			// 首次启动，从节点中获取，并初始化
-			latestBlockNumber, err := scanner.ethRequester.GetLatestBlockNumber()
-			if err != nil {
-				return err
-			}
-			latestBlock, err := scanner.ethRequester.GetBlockInfoByNumber(latestBlockNumber)
-			if err != nil {
-				return err
-			}
-			if latestBlock.Number == "" {
-				panic(latestBlockNumber.String())
-			}
-			scanner.lastBlock.BlockHash = latestBlock.Hash
-			scanner.lastBlock.ParentHash = latestBlock.ParentHash
-			scanner.lastBlock.BlockNumber = latestBlock.Number
-			scanner.lastBlock.CreateTime = scanner.hexToTen(latestBlock.Timestamp).Int64()
-			scanner.lastNumber = latestBlockNumber
-		} else {
-			scanner.lastNumber, _ = new(big.Int).SetString(scanner.lastBlock.BlockNumber, 10)
-			// 下面加 1，因为上一次数据库存的是已经遍历完了的
-			scanner.lastNumber.Add(scanner.lastNumber, new(big.Int).SetInt64(1))
+		if !scanner.isSubscribed() {
+			time.Sleep(1 * time.Second) // 轮询时延迟一秒开始下一轮
 		}
 		return nil
 	}
-	if err := init(); err != nil {
-		return err
-	}
-	execute := func() {
-		if err := scanner.scan(); nil != err {
-			scanner.log(err.Error())
+	for {
+		select {
+		case <-scanner.stop: // 监听是否退出遍历
+			scanner.log("finish block scanner!")
 			return
-		}
-		time.Sleep(1 * time.Second) // 延迟一秒开始下一轮
-	}
-	// 启动一个协程来遍历区块
-	go func() {
-		for {
-			select {
-			case <-scanner.stop: // 监听是否退出遍历
-				scanner.log("finish block scanner!")
-				return
-			default:
-				if !scanner.fork {
-					execute()
-					continue
-				}
-				if err := init(); err != nil {
+		default:
+			if !scanner.fork {
+				if err := execute(); err != nil {
+					if errors.Is(err, ErrForkResolution) {
+						// 分叉无法处理，继续遍历会写入错误的数据
+						scanner.fatal(err)
+						return
+					}
 					scanner.log(err.Error())
-					return
 				}
-				scanner.fork = false
+				continue
+			}
+			if err := init(); err != nil {
+				scanner.fatal(err)
+				return
 			}
+			scanner.fork = false
 		}
-	}()
-	return nil
+	}
 }
 
 // 公有函数，可以供外部调用来停止区块遍历
@@ -112,16 +156,16 @@ func (scanner *BlockScanner) Stop() {
 }
 
 // 判断是否分叉的函数，若为 true 则是分叉
-func (scanner *BlockScanner) isFork(currentBlock *dao.Block) bool {
+func (scanner *BlockScanner) isFork(currentBlock *dao.Block) (bool, error) {
 	if currentBlock.BlockNumber == "" {
-		panic("invalid block")
+		return false, errors.New("invalid block")
 	}
 	// scanner.lastBlock.BlockHash == currentBlock.ParentHash 判断上一次的区块哈希值是否是当前区块的父块哈希值
 	if scanner.lastBlock.BlockHash == currentBlock.BlockHash || scanner.lastBlock.BlockHash == currentBlock.ParentHash {
 		scanner.lastBlock = currentBlock // 没有发送分叉，更新上一次区块为当前被检测的区块
-		return false
+		return false, nil
 	}
-	return true
+	return true, nil
 }
 
 // 获取分叉点区块
@@ -203,13 +247,18 @@ func (scanner *BlockScanner) init() error {
 		if err != nil {
 			return err
 		}
+		// 设置了确认数时，从已经达到确认数的区块开始
+		confirmations := new(big.Int).SetUint64(scanner.confirmations)
+		if latestBlockNumber.Cmp(confirmations) >= 0 {
+			latestBlockNumber.Sub(latestBlockNumber, confirmations)
+		}
 		// GetBlockInfoByNumber 根据区块好获取区块数据
 		latestBlock, err := scanner.ethRequester.GetBlockInfoByNumber(latestBlockNumber)
 		if err != nil {
 			return err
 		}
 		if latestBlock.Number == "" {
-			panic(latestBlockNumber.String())
+			return fmt.Errorf("block info is empty %s", latestBlockNumber.String())
 		}
 		// 下面是给区块遍历器的 lastBlock 变量赋值
 		scanner.lastBlock.BlockHash = latestBlock.Hash
@@ -236,79 +285,108 @@ func (scanner *BlockScanner) hexToTen(hex string) *big.Int {
 	return ten
 }
 
-// 获取要扫描的区块号
+// 获取要扫描的区块号，最新区块号不够时等待新区块生成
+// 设置了确认数时，要等到最新区块号 >= 目标区块号 + 确认数
 func (scanner *BlockScanner) getScannerBlockNumber() (*big.Int, error) {
-	// 调用以太坊请求者 ethRequester 获取公链上最新生成的区块的区块号
-	newBlockNumber, err := scanner.ethRequester.GetLatestBlockNumber()
-	if err != nil {
-		return nil, err
-	}
-	latestNumber := newBlockNumber
 	// 下面使用 new 的形式初始化并设置值，不要直接赋值
 	// 否则会和 lastNumber 的内存地址一样，影响后面的获取区块信息
 	targetNumber := new(big.Int).Set(scanner.lastNumber)
+	required := new(big.Int).Add(targetNumber, new(big.Int).SetUint64(scanner.confirmations))
+	// 获取公链上最新生成的区块的区块号，订阅中时直接使用推送的区块号
+	latestNumber, err := scanner.latestNumber()
+	if err != nil {
+		return nil, err
+	}
+	scanner.notifyPending(latestNumber)
 	// 比较区块号大小
 	// -1 if x < y, 0 if x==y,+1 if x > y
-	if latestNumber.Cmp(scanner.lastNumber) < 0 {
-		// 最新的区块高度比设置的要小，则等待新区块高度 >= 设置的
-	Next:
-		for {
-			select {
-			case <-time.After(time.Duration(4 * time.Second)): // 延时 4 秒重新获取
-				number, err := scanner.ethRequester.GetLatestBlockNumber()
-				if err == nil && number.Cmp(scanner.lastNumber) >= 0 {
-					break Next // 跳出循环
-				}
-			}
+	for latestNumber.Cmp(required) < 0 {
+		// 最新的区块高度比需要的要小，则等待新区块生成
+		scanner.waitForHead()
+		number, err := scanner.latestNumber()
+		if err != nil {
+			continue
 		}
+		latestNumber = number
+		scanner.notifyPending(latestNumber)
 	}
 	return targetNumber, nil // 返回目标区块高度
 }
 
+// 通知 ConfirmationHandler 还没有达到确认数的新区块
+// 每个区块号只通知一次，分叉后同一个区块号可能会对应不同的区块哈希值
+func (scanner *BlockScanner) notifyPending(latestNumber *big.Int) {
+	handlers := scanner.confirmationHandlers()
+	if scanner.confirmations == 0 || len(handlers) == 0 {
+		return
+	}
+	// 确认数不够的区块是 (最新区块号 - 确认数, 最新区块号]，已经通知过的和已经保存的跳过
+	from := new(big.Int).Sub(latestNumber, new(big.Int).SetUint64(scanner.confirmations))
+	from.Add(from, big.NewInt(1))
+	if scanner.pendingNumber != nil && scanner.pendingNumber.Cmp(from) >= 0 {
+		from = new(big.Int).Add(scanner.pendingNumber, big.NewInt(1))
+	}
+	if from.Cmp(scanner.lastNumber) < 0 {
+		from = new(big.Int).Set(scanner.lastNumber)
+	}
+	for number := from; number.Cmp(latestNumber) <= 0; number = new(big.Int).Add(number, big.NewInt(1)) {
+		fullBlock, err := scanner.ethRequester.GetBlockInfoByNumber(number)
+		if err != nil {
+			scanner.log("获取待确认区块失败", err.Error())
+			return // 下一次获取到最新区块号时再通知
+		}
+		confirmations := new(big.Int).Sub(latestNumber, number).Uint64()
+		for _, handler := range handlers {
+			if err := handler.OnPending(fullBlock, confirmations); err != nil {
+				scanner.log("pending handler error", err.Error())
+			}
+		}
+		scanner.pendingNumber = number
+	}
+}
+
 // 扫描区块
 func (scanner *BlockScanner) scan() error {
-	// 获取公链上最新生成的区块
-	newBlockNumber, err := scanner.ethRequester.GetLatestBlockNumber()
+	// 获取要扫描的区块号，区块还没有生成或者确认数不够时会在这里等待
+	targetNumber, err := scanner.getScannerBlockNumber()
 	if err != nil {
 		return err
 	}
-
-	latestNumber := newBlockNumber
-	targetNumber := scanner.lastNumber
-	// 比较区块号大小
-	// -1 if x <  y
-	//  0 if x == y
-	// +1 if x >  y
-	if latestNumber.Cmp(scanner.lastNumber) < 0 {
-		// 小，则等待新区块生成
-	Next:
-		for {
-			select {
-			case <-time.After(time.Duration(4 * time.Second)):
-				number, err := scanner.ethRequester.GetLatestBlockNumber()
-				if err == nil && number.Cmp(scanner.lastNumber) >= 0 {
-					targetNumber = number
-					break Next
-				}
-			}
-		}
-	}
 	// 获取区块信息
 	fullBlock, err := scanner.retryGetBlockInfoByNumber(targetNumber)
 	if err != nil {
 		return err
 	}
-	// 区块号自增 1
+	// 在开启事务之前获取交易收据，获取失败时下一轮重新遍历这个区块
+	receipts, err := scanner.getBlockReceipts(fullBlock)
+	if err != nil {
+		return err
+	}
+	if err := scanner.saveBlock(fullBlock, receipts); err != nil {
+		return err
+	}
+	// 保存成功后区块号才自增 1，失败时下一轮重新遍历这个区块
 	scanner.lastNumber.Add(scanner.lastNumber, new(big.Int).SetInt64(1))
+	return nil
+}
 
+// 在一个数据库事务中保存区块、交易、收据和 ERC20 转账记录，并调用注册的处理器
+// 任何一步失败都会回滚整个区块
+func (scanner *BlockScanner) saveBlock(fullBlock *model.FullBlock, receipts []*model.Receipt) error {
 	// 开启数据库事务
 	tx := scanner.mysql.Db.NewSession()
 	defer tx.Close()
+	if err := tx.Begin(); err != nil {
+		return err
+	}
 
 	// 准备保存区块信息，先判断当前区块记录是否已经存在
 	block := dao.Block{}
-	_, err = tx.Where("block_hash=?", fullBlock.Hash).Get(&block)
-	if err == nil && block.Id == 0 {
+	if _, err := tx.Where("block_hash=?", fullBlock.Hash).Get(&block); err != nil {
+		tx.Rollback() // 事务回滚
+		return err
+	}
+	if block.Id == 0 {
 		// 不存在，进行添加
 		block.BlockNumber = scanner.hexToTen(fullBlock.Number).String()
 		block.ParentHash = fullBlock.ParentHash
@@ -321,74 +399,142 @@ func (scanner *BlockScanner) scan() error {
 		}
 	}
 	// 检查区块是否分叉
-	if scanner.forkCheck(&block) {
+	fork, err := scanner.forkCheck(tx, &block)
+	if err != nil {
+		tx.Rollback() // 事务回滚
+		return err
+	}
+	if fork {
 		data, _ := json.Marshal(fullBlock)
 		scanner.log("分叉！", string(data))
-		tx.Commit()
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 		scanner.fork = true // 发生分叉
 		return errors.New("fork check")
 	}
 
-	// 解析区块内数据，读取内部的 “transactions” 交易信息，分析得出各种合约事件
 	scanner.log("scan block start ==> ", "number: ", scanner.hexToTen(fullBlock.Number), "hash: ", fullBlock.Hash)
-	for index, transaction := range fullBlock.Transactions {
-		// 下面的打印操作模拟自定义处理。对于每条 tx，我们是完全可以进一步从里面提取信息的！
-		scanner.log("tx hash ==> ", transaction.Hash)
-		if index > 5 {
-			// 控制只打印 5 条
-			break
-		}
-	}
-	scanner.log("scan block finish \n=================")
 	// 数据库保存交易信息
 	if _, err = tx.Insert(&fullBlock.Transactions); err != nil {
 		tx.Rollback() // 事务回滚
 		return err
 	}
+	// 数据库保存交易收据
+	if scanner.saveReceipts {
+		daoReceipts := []dao.Receipt{}
+		for _, receipt := range receipts {
+			daoReceipts = append(daoReceipts, receipt.ToDao())
+		}
+		if _, err = tx.Insert(&daoReceipts); err != nil {
+			tx.Rollback() // 事务回滚
+			return err
+		}
+	}
+	// 数据库保存 ERC20 转账记录
+	if scanner.indexERC20 {
+		transfers := decodeERC20Transfers(receipts)
+		if _, err = tx.Insert(&transfers); err != nil {
+			tx.Rollback() // 事务回滚
+			return err
+		}
+		scanner.log("erc20 transfers ==> ", len(transfers))
+	}
+	// 解析区块内数据，读取内部的 “transactions” 交易信息，交给注册的处理器进行自定义处理
+	if err = scanner.runHandlers(tx, fullBlock, receipts); err != nil {
+		tx.Rollback() // 事务回滚
+		return err
+	}
+	// 回填时和区块在同一个事务中更新回填进度，中断后不会重复保存区块
+	if scanner.checkpoint != nil {
+		if err = scanner.updateCheckpoint(tx, scanner.hexToTen(fullBlock.Number).Int64()+1); err != nil {
+			tx.Rollback() // 事务回滚
+			return err
+		}
+	}
+	scanner.log("scan block finish \n=================")
 	return tx.Commit()
 }
 
+// 批量获取区块中所有交易的收据，不需要收据时返回空数组
+func (scanner *BlockScanner) getBlockReceipts(fullBlock *model.FullBlock) ([]*model.Receipt, error) {
+	if (!scanner.saveReceipts && !scanner.indexERC20) || len(fullBlock.Transactions) == 0 {
+		return nil, nil
+	}
+	txHashs := []string{}
+	for _, transaction := range fullBlock.Transactions {
+		txHashs = append(txHashs, transaction.Hash)
+	}
+	receipts, err := scanner.ethRequester.GetTransactionReceipts(txHashs)
+	if err != nil {
+		return nil, fmt.Errorf("获取区块交易收据失败 %s", err.Error())
+	}
+	for index, receipt := range receipts {
+		if receipt == nil {
+			// 区块已经生成，收据却为空，可能是节点还没有同步完，下一轮重试
+			return nil, fmt.Errorf("receipt is empty %s", txHashs[index])
+		}
+	}
+	return receipts, nil
+}
+
 // 检测分叉，返回 true 是分叉
-func (scanner *BlockScanner) forkCheck(currentBlock *dao.Block) bool {
+// 分叉记录的标记在 session 事务中进行，无法处理分叉时返回的错误包含 ErrForkResolution
+func (scanner *BlockScanner) forkCheck(session *xorm.Session, currentBlock *dao.Block) (bool, error) {
 	if currentBlock.BlockNumber == "" {
-		panic("invalid block")
+		return false, fmt.Errorf("%w: invalid block", ErrForkResolution)
 	}
 	if scanner.lastBlock.BlockHash == currentBlock.BlockHash || scanner.lastBlock.BlockHash == currentBlock.ParentHash {
 		scanner.lastBlock = currentBlock // 更新
-		return false
+		return false, nil
 	}
 	// 获取出最初开始分叉的那个区块
 	forkBlock, err := scanner.getForkBlock(currentBlock.ParentHash)
 	if err != nil {
-		panic(err)
+		return false, fmt.Errorf("%w: %s", ErrForkResolution, err.Error())
 	}
-	scanner.lastBlock = forkBlock // 更新。从这个区块开始，其之后的都是分叉的
 
 	// 修改数据库记录，将分叉区块标记好
-	numberEnd := ""
-	if strings.HasPrefix(currentBlock.BlockNumber, "0x") {
-		c, _ := new(big.Int).SetString(currentBlock.BlockNumber[2:], 16)
-		numberEnd = c.String()
-	} else {
-		c, _ := new(big.Int).SetString(currentBlock.BlockNumber, 10)
-		numberEnd = c.String()
+	// 区块号按照整数传入，区块表的 block_number 是字符串，按照文本比较时 "10" <= "9"
+	numberEnd := scanner.hexToTen(currentBlock.BlockNumber)
+	numberFrom := scanner.hexToTen(forkBlock.BlockNumber)
+	if numberEnd == nil || numberFrom == nil {
+		return false, fmt.Errorf("%w: invalid block number %s %s", ErrForkResolution, forkBlock.BlockNumber, currentBlock.BlockNumber)
 	}
-	numberFrom := forkBlock.BlockNumber
-	_, err = scanner.mysql.Db.
+	// 当前区块是新的主链区块，刚刚在同一个事务中插入，不能标记为分叉
+	_, err = session.
 		Table(dao.Block{}).
-		Where("block_number > ? and block_number <= ?", numberFrom, numberEnd). // 区块号范围内
+		Where("block_number > ? and block_number <= ? and block_hash != ?", numberFrom.Int64(), numberEnd.Int64(), currentBlock.BlockHash). // 区块号范围内
 		Update(map[string]bool{"fork": true})
 	if err != nil {
-		panic(fmt.Errorf("update fork block failed %s", err.Error()))
+		return false, fmt.Errorf("%w: update fork block failed %s", ErrForkResolution, err.Error())
 	}
-	return true
+	// 分叉区块中的 ERC20 转账记录同样标记为分叉
+	_, err = session.
+		Table(dao.TokenTransfer{}).
+		Where("block_number > ? and block_number <= ?", numberFrom.Int64(), numberEnd.Int64()).
+		Update(map[string]bool{"fork": true})
+	if err != nil {
+		return false, fmt.Errorf("%w: update fork token transfer failed %s", ErrForkResolution, err.Error())
+	}
+	// 通知处理器撤销分叉区块的数据，处理失败时无法保证数据正确，只能停止遍历
+	for _, handler := range scanner.handlers {
+		if err := handler.OnReorg(session, forkBlock, currentBlock); err != nil {
+			return false, fmt.Errorf("%w: handler reorg failed %s", ErrForkResolution, err.Error())
+		}
+	}
+	scanner.lastBlock = forkBlock // 更新。从这个区块开始，其之后的都是分叉的
+	return true, nil
 }
 
 func (scanner *BlockScanner) getForkBlock(parentHash string) (*dao.Block, error) {
 	// 获取当前区块的父区块，分叉从父区块开始
 	parent := dao.Block{}
 	_, err := scanner.mysql.Db.Where("block_hash=?", parentHash).Get(&parent)
-	if err == nil && parent.BlockNumber != "" {
+	if err != nil {
+		return nil, fmt.Errorf("查询分叉区块失败 %s", err.Error())
+	}
+	if parent.BlockNumber != "" {
 		return &parent, nil
 	}
 	// 数据库没有父区块记录，准备从以太坊接口获取
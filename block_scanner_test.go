@@ -2,13 +2,26 @@ package main
 
 import (
 	"eth-relay/dao"
+	"eth-relay/model"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-xorm/xorm"
 )
 
 // 单元测试：区块扫描器，开始扫描区块
 func TestBlockScanner_Start(t *testing.T) {
 	// 初始化以太坊 rpc 请求者
-	requester := NewETHRPCRequester("https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71")
+	requester, err := NewETHRPCRequester("https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71")
+	if err != nil {
+		fmt.Println("初始化请求者失败", err.Error())
+		return
+	}
+	defer requester.Close()
 	// 初始化数据库连接器配置对象，记得修改为本地数据库的参数
 	option := dao.MySQLOptions{
 		HostName:           "127.0.0.1",
@@ -23,15 +36,346 @@ func TestBlockScanner_Start(t *testing.T) {
 	}
 	// 添加数据表
 	tables := []interface{}{}
-	tables = append(tables, dao.Block{}, dao.Transaction{})
+	tables = append(tables, dao.Block{}, dao.Transaction{}, dao.Receipt{}, dao.TokenTransfer{}, dao.BackfillCheckpoint{})
 	// 根据上面定义的配置，初始化数据库连接器
-	mysql := dao.NewMySQLConnector(&option, tables)
+	mysql, err := dao.NewMySQLConnector(&option, tables)
+	if err != nil {
+		fmt.Println("数据库初始化失败", err.Error())
+		return
+	}
 	// 初始化区块扫描器
 	scanner := NewBlockScanner(*requester, mysql)
-	err := scanner.Start() // 开始扫描
+	scanner.SetSaveReceipts(true)        // 同时保存交易收据
+	scanner.SetIndexERC20Transfers(true) // 同时保存 ERC20 转账记录
+	// 注册自定义处理器，这里只打印每个区块的前 5 条交易
+	scanner.RegisterHandler(&LogBlockHandler{MaxTransactions: 5})
+	err = scanner.Start() // 开始扫描
 	if err != nil {
 		panic(err)
 	}
-	// 使用 select 模拟阻塞主协程，等待上面的代码执行，因为扫描是在 gorutine 协程中进行的
-	select {}
+	// 阻塞主协程，等待上面的代码执行，因为扫描是在 gorutine 协程中进行的
+	// 遍历器遇到无法处理的错误停止时，会从 Errors 管道收到原因
+	err = <-scanner.Errors()
+	t.Fatal(err)
+}
+
+// 单元测试：从事件日志中解析 ERC20 转账
+func Test_DecodeERC20Transfer(t *testing.T) {
+	log := model.Log{
+		Address: "0xdAC17F958D2ee523a2206206994597C13D831ec7", // USDT 合约
+		Topics: []string{
+			erc20TransferTopic,
+			"0x0000000000000000000000004ad64983349c49defe8d7a4686202d24b25d0ce8",
+			"0x00000000000000000000000097376cf11717ab4a9e9a94042e895640a6262e30",
+		},
+		Data:            "0x00000000000000000000000000000000000000000000000000000000000f4240",
+		BlockNumber:     "0xe4e1c0",
+		LogIndex:        "0x1a",
+		TransactionHash: "0xd34279f67e05c398a863177b73b735a6141deba3bda62342a8f2c91f36a22f8e",
+	}
+	transfer, ok := decodeERC20Transfer(log)
+	if !ok {
+		t.Fatal("应该解析为 ERC20 转账")
+	}
+	if transfer.FromAddress != "0x4ad64983349c49defe8d7a4686202d24b25d0ce8" ||
+		transfer.ToAddress != "0x97376cf11717ab4a9e9a94042e895640a6262e30" ||
+		transfer.Amount != "1000000" || transfer.LogIndex != 26 || transfer.BlockNumber != 15000000 {
+		t.Fatalf("解析结果错误 %+v", transfer)
+	}
+	// ERC721 的 Transfer 事件有 4 个 topic，不是 ERC20 转账
+	log.Topics = append(log.Topics, "0x0000000000000000000000000000000000000000000000000000000000000001")
+	log.Data = "0x"
+	if _, ok := decodeERC20Transfer(log); ok {
+		t.Fatal("ERC721 转账不应该解析为 ERC20 转账")
+	}
+	fmt.Printf("%+v\n", transfer)
+}
+
+// 单元测试：分叉范围跨过位数变化时，区块和 ERC20 转账记录都按照数值标记分叉
+func Test_ForkCheckTokenTransfers(t *testing.T) {
+	option := dao.MySQLOptions{
+		HostName:           "127.0.0.1",
+		Port:               "3306",
+		DbName:             "eth_reply",
+		User:               "root",
+		Password:           "",
+		TablePrefix:        "eth_",
+		MaxOpenConnections: 10,
+		MaxIdleConnections: 5,
+		ConnMaxLifetime:    15,
+	}
+	mysql, err := dao.NewMySQLConnector(&option, []interface{}{dao.Block{}, dao.TokenTransfer{}})
+	if err != nil {
+		fmt.Println("数据库初始化失败", err.Error())
+		return
+	}
+	hashes := []string{"0xfork-test-9", "0xfork-test-10", "0xfork-test-10b"}
+	clean := func() {
+		mysql.Db.In("block_hash", hashes).Delete(&dao.Block{})
+		mysql.Db.In("block_hash", hashes).Delete(&dao.TokenTransfer{})
+	}
+	clean()
+	defer clean()
+	// 区块 9 是分叉点，旧链上的区块 10 被新链上的区块 10 替换
+	forkPoint := dao.Block{BlockNumber: "9", BlockHash: hashes[0], ParentHash: "0xfork-test-8"}
+	oldBlock := dao.Block{BlockNumber: "10", BlockHash: hashes[1], ParentHash: hashes[0]}
+	newBlock := dao.Block{BlockNumber: "10", BlockHash: hashes[2], ParentHash: hashes[0]}
+	if _, err := mysql.Db.Insert(&forkPoint, &oldBlock, &newBlock); err != nil {
+		t.Fatal(err)
+	}
+	transfers := []dao.TokenTransfer{
+		{TxHash: "0xfork-test-tx-9", BlockHash: hashes[0], BlockNumber: 9},
+		{TxHash: "0xfork-test-tx-10", BlockHash: hashes[1], BlockNumber: 10},
+	}
+	if _, err := mysql.Db.Insert(&transfers); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := NewBlockScanner(ETHRPCRequester{}, mysql)
+	scanner.lastBlock = &oldBlock
+	session := mysql.Db.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	fork, err := scanner.forkCheck(session, &newBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !fork || scanner.lastBlock.BlockHash != forkPoint.BlockHash {
+		t.Fatalf("应该检测到分叉并回退到区块 9 %v %s", fork, scanner.lastBlock.BlockHash)
+	}
+	blocks := []dao.Block{}
+	if err := mysql.Db.In("block_hash", hashes).Find(&blocks); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		if block.Fork != (block.BlockHash == oldBlock.BlockHash) {
+			t.Fatalf("区块 %s 的分叉标记错误 %v", block.BlockHash, block.Fork)
+		}
+	}
+	saved := []dao.TokenTransfer{}
+	if err := mysql.Db.In("block_hash", hashes).Find(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("转账记录数量错误 %d", len(saved))
+	}
+	for _, transfer := range saved {
+		if transfer.Fork != (transfer.BlockNumber == 10) {
+			t.Fatalf("区块 %d 的转账分叉标记错误 %v", transfer.BlockNumber, transfer.Fork)
+		}
+	}
+}
+
+// 测试用的处理器，记录被调用的次数和收到的收据
+type countBlockHandler struct {
+	BaseBlockHandler
+	blocks   int
+	txs      int
+	receipts int
+}
+
+func (h *countBlockHandler) OnBlock(session *xorm.Session, block *model.FullBlock) error {
+	h.blocks++
+	return nil
+}
+
+func (h *countBlockHandler) OnTransaction(session *xorm.Session, block *model.FullBlock, transaction *dao.Transaction, receipt *model.Receipt) error {
+	h.txs++
+	if receipt != nil && receipt.TransactionHash == transaction.Hash {
+		h.receipts++
+	}
+	return nil
+}
+
+// 单元测试：注册的处理器按顺序处理区块和交易，交易能拿到对应的收据
+func Test_RunHandlers(t *testing.T) {
+	scanner := NewBlockScanner(ETHRPCRequester{}, dao.MySQLConnector{})
+	handler := &countBlockHandler{}
+	scanner.RegisterHandler(handler)
+	scanner.RegisterHandler(&LogBlockHandler{MaxTransactions: 1})
+	block := &model.FullBlock{Transactions: []dao.Transaction{
+		{Hash: "0x01", TransactionIndex: "0x0"},
+		{Hash: "0x02", TransactionIndex: "0x1"},
+	}}
+	receipts := []*model.Receipt{{TransactionHash: "0x02"}}
+	if err := scanner.runHandlers(nil, block, receipts); err != nil {
+		t.Fatal(err)
+	}
+	if handler.blocks != 1 || handler.txs != 2 || handler.receipts != 1 {
+		t.Fatalf("处理器调用次数错误 %+v", handler)
+	}
+}
+
+type confirmationBlockHandler struct {
+	BaseBlockHandler
+	pending   map[string]uint64 // 区块号 -> 通知时的确认数
+	confirmed int
+}
+
+func (h *confirmationBlockHandler) OnPending(block *model.FullBlock, confirmations uint64) error {
+	if _, ok := h.pending[block.Number]; ok {
+		return fmt.Errorf("区块 %s 重复通知", block.Number)
+	}
+	h.pending[block.Number] = confirmations
+	return nil
+}
+
+func (h *confirmationBlockHandler) OnConfirmed(session *xorm.Session, block *model.FullBlock) error {
+	h.confirmed++
+	return nil
+}
+
+// 单元测试：确认数不够的区块只通知一次 OnPending，保存区块时通知 OnConfirmed
+func Test_BlockConfirmations(t *testing.T) {
+	service := &testEthService{number: 10}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	scanner := NewBlockScanner(*requester, dao.MySQLConnector{})
+	scanner.SetConfirmations(3)
+	handler := &confirmationBlockHandler{pending: map[string]uint64{}}
+	scanner.RegisterHandler(handler)
+	scanner.lastNumber = big.NewInt(5)
+
+	// 最新区块 10，确认数 3，8 到 10 还没有达到确认数
+	scanner.notifyPending(big.NewInt(10))
+	if len(handler.pending) != 3 || handler.pending["0x8"] != 2 || handler.pending["0xa"] != 0 {
+		t.Fatalf("待确认区块通知错误 %v", handler.pending)
+	}
+	// 新区块只通知新出现的部分
+	service.number = 12
+	scanner.notifyPending(big.NewInt(12))
+	if len(handler.pending) != 5 || handler.pending["0xc"] != 0 {
+		t.Fatalf("待确认区块通知错误 %v", handler.pending)
+	}
+
+	// 目标区块 5 + 确认数 3 <= 最新区块 12，不需要等待
+	number, err := scanner.getScannerBlockNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number.Int64() != 5 || number == scanner.lastNumber {
+		t.Fatalf("目标区块号错误 %v", number)
+	}
+	if err := scanner.runHandlers(nil, &model.FullBlock{Number: "0x5"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if handler.confirmed != 1 {
+		t.Fatalf("OnConfirmed 调用次数错误 %d", handler.confirmed)
+	}
+}
+
+// 单元测试：回填时并发获取区块，按照区块号顺序保存
+func Test_FetchBackfill(t *testing.T) {
+	requester := newTestRequester(t, startTestIPCNode(t, &testEthService{number: 100}))
+	scanner := NewBlockScanner(*requester, dao.MySQLConnector{})
+	scanner.SetBackfillConcurrency(3)
+	scanner.SetBackfillBatchSize(7)
+	saved := []string{}
+	err := scanner.fetchBackfill(big.NewInt(10), big.NewInt(60), func(fullBlock *model.FullBlock, receipts []*model.Receipt) error {
+		saved = append(saved, fullBlock.Number)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 51 {
+		t.Fatalf("回填区块数量错误 %d", len(saved))
+	}
+	for index, number := range saved {
+		if scanner.hexToTen(number).Int64() != int64(10+index) {
+			t.Fatalf("回填区块顺序错误 %v", saved)
+		}
+	}
+
+	// 保存失败时停止回填并返回错误
+	count := 0
+	err = scanner.fetchBackfill(big.NewInt(10), big.NewInt(60), func(fullBlock *model.FullBlock, receipts []*model.Receipt) error {
+		count++
+		if count == 3 {
+			return fmt.Errorf("save failed")
+		}
+		return nil
+	})
+	if err == nil || count != 3 {
+		t.Fatalf("保存失败时应该停止回填 %v %d", err, count)
+	}
+}
+
+// 单元测试：订阅新区块头后由推送唤醒遍历，不再请求 eth_blockNumber
+func Test_SubscribeHeads(t *testing.T) {
+	service := &testEthService{number: 10, heads: make(chan *model.BlockHeader)}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	scanner := NewBlockScanner(*requester, dao.MySQLConnector{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go scanner.subscribeHeads(quit)
+	for i := 0; i < 50 && !scanner.isSubscribed(); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !scanner.isSubscribed() {
+		t.Fatal("没有订阅成功")
+	}
+	// 还没有收到区块头时从节点获取
+	number, err := scanner.latestNumber()
+	if err != nil || number.Int64() != 10 {
+		t.Fatalf("最新区块号错误 %v %v", number, err)
+	}
+	// 推送的区块号跳过了 12，遍历被唤醒并且直接使用推送的区块号
+	service.heads <- &model.BlockHeader{Number: "0xb"}
+	service.heads <- &model.BlockHeader{Number: "0xd"}
+	start := time.Now()
+	for {
+		scanner.waitForHead()
+		number, err = scanner.latestNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number.Int64() == 13 {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("没有收到推送的区块头 %v", number)
+		}
+	}
+}
+
+// 单元测试：启动时最健康的节点是 http 节点，切换到 ipc 节点后开始订阅
+func Test_SubscribeHeadsAfterFailover(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testEthService{number: 10}); err != nil {
+		t.Fatal(err)
+	}
+	httpNode := httptest.NewServer(server)
+	defer server.Stop()
+	pool, err := NewETHRPCClientPool([]NodeConfig{
+		{Url: httpNode.URL, Priority: 0},
+		{Url: startTestIPCNode(t, &testEthService{number: 10, heads: make(chan *model.BlockHeader)}), Priority: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requester := NewETHRPCRequesterWithPool(pool)
+	defer requester.Close()
+	scanner := NewBlockScanner(*requester, dao.MySQLConnector{})
+	scanner.resubscribeInterval = 20 * time.Millisecond
+	quit := make(chan struct{})
+	defer close(quit)
+	go scanner.subscribeHeads(quit)
+	time.Sleep(100 * time.Millisecond)
+	if scanner.isSubscribed() {
+		t.Fatal("http 节点不支持订阅")
+	}
+	// http 节点故障，节点池切换到 ipc 节点
+	httpNode.Close()
+	pool.CheckHealth()
+	for i := 0; i < 50 && !scanner.isSubscribed(); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !scanner.isSubscribed() {
+		t.Fatal("切换到 ipc 节点后没有订阅")
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"eth-relay/model"
+	"math/big"
+	"time"
+)
+
+// 订阅断开后重新订阅的间隔，期间使用轮询获取最新区块号
+// 当前节点不支持订阅时也按照这个间隔重新检查，节点池切换到 ws 或者 ipc 节点后开始订阅
+const resubscribeInterval = 5 * time.Second
+
+// 订阅新区块头，直到 quit 被关闭
+// 订阅断开或者当前节点不支持订阅时使用轮询，并定时重新订阅
+func (scanner *BlockScanner) subscribeHeads(quit chan struct{}) {
+	for {
+		if scanner.ethRequester.SupportsSubscription() {
+			if done := scanner.receiveHeads(quit); done {
+				return
+			}
+		}
+		select {
+		case <-quit:
+			return
+		case <-time.After(scanner.resubscribeInterval):
+		}
+	}
+}
+
+// 订阅一次新区块头并接收推送，直到订阅断开，quit 被关闭时返回 true
+func (scanner *BlockScanner) receiveHeads(quit chan struct{}) bool {
+	heads := make(chan *model.BlockHeader, 16)
+	sub, err := scanner.ethRequester.SubscribeNewHeads(context.Background(), heads)
+	if err != nil {
+		scanner.log("订阅新区块失败，使用轮询", err.Error())
+		return false
+	}
+	scanner.log("订阅新区块成功")
+	scanner.setSubscribed(true)
+	defer scanner.setSubscribed(false)
+	for {
+		select {
+		case header := <-heads:
+			scanner.onHead(header)
+		case err := <-sub.Err():
+			// 订阅断开，断开期间错过的区块由遍历按照区块号补齐
+			if err != nil {
+				scanner.log("新区块订阅断开，使用轮询", err.Error())
+			}
+			return false
+		case <-quit:
+			sub.Unsubscribe()
+			return true
+		}
+	}
+}
+
+// 收到新区块头，记录最新区块号并唤醒等待中的遍历
+func (scanner *BlockScanner) onHead(header *model.BlockHeader) {
+	number := scanner.hexToTen(header.Number)
+	if number == nil {
+		return
+	}
+	scanner.headLock.Lock()
+	previous := scanner.head
+	scanner.head = number
+	scanner.headLock.Unlock()
+	if previous != nil && number.Cmp(new(big.Int).Add(previous, big.NewInt(1))) > 0 {
+		// 区块头不连续，中间的区块同样会被遍历到，这里只记录日志
+		scanner.log("新区块头不连续，缺少区块", new(big.Int).Add(previous, big.NewInt(1)).String(), "-", new(big.Int).Sub(number, big.NewInt(1)).String())
+	}
+	select {
+	case scanner.newHead <- struct{}{}:
+	default: // 遍历还没有处理上一次的唤醒
+	}
+}
+
+func (scanner *BlockScanner) setSubscribed(subscribed bool) {
+	scanner.headLock.Lock()
+	defer scanner.headLock.Unlock()
+	scanner.subscribed = subscribed
+	if !subscribed {
+		scanner.head = nil // 订阅断开后记录的区块号不再更新，重新从节点获取
+	}
+}
+
+// 是否正在通过订阅获取新区块
+func (scanner *BlockScanner) isSubscribed() bool {
+	scanner.headLock.Lock()
+	defer scanner.headLock.Unlock()
+	return scanner.subscribed
+}
+
+// 获取最新区块号，订阅中并且已经收到过区块头时不需要请求节点
+func (scanner *BlockScanner) latestNumber() (*big.Int, error) {
+	scanner.headLock.Lock()
+	if scanner.subscribed && scanner.head != nil {
+		head := new(big.Int).Set(scanner.head)
+		scanner.headLock.Unlock()
+		return head, nil
+	}
+	scanner.headLock.Unlock()
+	return scanner.ethRequester.GetLatestBlockNumber()
+}
+
+// 等待新区块生成，订阅中时被新区块头唤醒，否则延时 4 秒后重新获取
+func (scanner *BlockScanner) waitForHead() {
+	if !scanner.isSubscribed() {
+		time.Sleep(4 * time.Second)
+		return
+	}
+	select {
+	case <-scanner.newHead:
+	case <-time.After(4 * time.Second): // 防止订阅在等待期间断开
+	}
+}
@@ -0,0 +1,11 @@
+package dao
+
+// 存储历史区块回填进度的结构体，回填中断后从 NextNumber 继续
+type BackfillCheckpoint struct {
+	Id         int64 `json:"id"`          // 主键
+	FromNumber int64 `json:"from_number"` // 回填的起始区块号
+	ToNumber   int64 `json:"to_number"`   // 回填的结束区块号，-1 代表一直追到最新区块
+	NextNumber int64 `json:"next_number"` // 下一个要回填的区块号
+	Finished   bool  `json:"finished"`    // 是否已经回填完成，追到最新区块的回填完成后交给实时遍历
+	UpdateTime int64 `json:"update_time"` // 最近一次更新进度的时间戳，单位为秒
+}
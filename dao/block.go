@@ -6,6 +6,6 @@ type Block struct {
 	BlockNumber string `json:"block_number"` // 区块号
 	BlockHash   string `json:"block_hash"`   // 区块的哈希值
 	ParentHash  string `json:"parent_hash"`  // 父区块的哈希值
-	CreateTime  int64  `json:create_time`    // 区块的生成时间
+	CreateTime  int64  `json:"create_time"`  // 区块的生成时间
 	Fork        bool   `json:"fork"`         // 是否为分叉区块
 }
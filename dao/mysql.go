@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,6 +10,13 @@ import (
 	"xorm.io/core"
 )
 
+var (
+	// ErrDialFailed 代表数据库初始化或者连接失败
+	ErrDialFailed = errors.New("dial mysql failed")
+	// ErrSchemaSync 代表创建或者同步数据表失败
+	ErrSchemaSync = errors.New("sync mysql schema failed")
+)
+
 type MySQLOptions struct {
 	HostName           string // 数据库服务器域名
 	Port               string // 端口
@@ -29,7 +37,8 @@ type MySQLConnector struct {
 }
 
 // tables 是数据表的结构体实例数组
-func NewMySQLConnector(options *MySQLOptions, tables []interface{}) MySQLConnector {
+// 连接失败时返回的错误包含 ErrDialFailed，建表失败时包含 ErrSchemaSync
+func NewMySQLConnector(options *MySQLOptions, tables []interface{}) (MySQLConnector, error) {
 	var connector MySQLConnector
 	connector.options = options
 	connector.tables = tables
@@ -42,7 +51,7 @@ func NewMySQLConnector(options *MySQLOptions, tables []interface{}) MySQLConnect
 	}
 	db, err := xorm.NewEngine("mysql", url) // 以 MySQL 数据可类型实例化
 	if err != nil {
-		panic(fmt.Errorf("数据库初始化失败 %s", err.Error()))
+		return connector, fmt.Errorf("%w: 数据库初始化失败 %s", ErrDialFailed, err.Error())
 	}
 	tbMapper := core.NewPrefixMapper(core.SnakeMapper{}, options.TablePrefix)
 	db.SetTableMapper(tbMapper)
@@ -51,14 +60,15 @@ func NewMySQLConnector(options *MySQLOptions, tables []interface{}) MySQLConnect
 	db.DB().SetMaxOpenConns(options.MaxOpenConnections)
 	// db.ShowSQL(true) // 是否开启打印 SQL 日志到控制台
 	if err = db.Ping(); err != nil {
-		panic(fmt.Errorf("数据库连接失败 %s", err.Error()))
+		db.Close()
+		return connector, fmt.Errorf("%w: 数据库连接失败 %s", ErrDialFailed, err.Error())
 	}
 	connector.Db = db
 	// 创建数据表，策略是不存在则创建
 	if err := connector.createTables(); err != nil {
-		panic(fmt.Errorf("创建数据表失败 %s", err.Error()))
+		return connector, fmt.Errorf("%w: 创建数据表失败 %s", ErrSchemaSync, err.Error())
 	}
-	return connector
+	return connector, nil
 }
 
 // 创建数据表
@@ -18,9 +18,13 @@ func Test_NewMySQLConnector(t *testing.T) {
 		MaxIdleConnections: 5,
 		ConnMaxLifetime:    15,
 	}
-	tables := []interface{}{}                       // 不创建数据表
-	tables = append(tables, Block{}, Transaction{}) // 添加数据表的数据结构体
-	mysql := NewMySQLConnector(&options, tables)
+	tables := []interface{}{}                                                                                                                                     // 不创建数据表
+	tables = append(tables, Block{}, Transaction{}, Receipt{}, TokenTransfer{}, BackfillCheckpoint{}, Nonce{}, NonceReservation{}, TrackedTransaction{}, Token{}) // 添加数据表的数据结构体
+	mysql, err := NewMySQLConnector(&options, tables)
+	if err != nil {
+		fmt.Println("数据库初始化失败", err.Error())
+		return
+	}
 	if mysql.Db.Ping() == nil {
 		fmt.Println("数据库连接成功")
 	} else {
@@ -0,0 +1,20 @@
+package dao
+
+// 存储地址下一个可用 nonce 的结构体，多个中继实例共用一个钱包时通过它分配 nonce
+type Nonce struct {
+	Id         int64  `json:"id"`                    // 主键
+	Address    string `xorm:"unique" json:"address"` // 小写的以太坊地址
+	NextNonce  uint64 `json:"next_nonce"`            // 下一个分配出去的 nonce
+	Generation uint64 `json:"generation"`            // 最后一次预留的代数，每次预留加一
+	UpdateTime int64  `json:"update_time"`           // 最近一次更新的时间戳，单位为秒
+}
+
+// 已经分配出去、还没有被链上确认的 nonce
+type NonceReservation struct {
+	Id         int64  `json:"id"`                                   // 主键
+	Address    string `xorm:"unique(address_nonce)" json:"address"` // 小写的以太坊地址
+	Nonce      uint64 `xorm:"unique(address_nonce)" json:"nonce"`   // 分配出去的 nonce
+	Status     string `json:"status"`                               // reserved 已预留，committed 已广播，released 已归还
+	Generation uint64 `json:"generation"`                           // 预留的代数，Commit 和 Release 时用来确认是同一次预留
+	UpdateTime int64  `json:"update_time"`                          // 最近一次更新状态的时间戳，单位为秒
+}
@@ -0,0 +1,19 @@
+package dao
+
+// 存储交易收据的结构体
+type Receipt struct {
+	Id                int64  `json:"id"`                  // 主键
+	TransactionHash   string `json:"transaction_hash"`    // 交易的哈希值
+	TransactionIndex  string `json:"transaction_index"`   // 交易在区块中的下标
+	BlockHash         string `json:"block_hash"`          // 交易被打包的区块的哈希值
+	BlockNumber       string `json:"block_number"`        // 交易被打包的区块的区块号
+	From              string `json:"from"`                // 交易发起者的地址
+	To                string `json:"to"`                  // 交易接收者的地址
+	Type              string `json:"type"`                // 交易类型
+	Status            string `json:"status"`              // 0x1 成功，0x0 失败
+	GasUsed           string `json:"gas_used"`            // 实际消耗的燃料
+	CumulativeGasUsed string `json:"cumulative_gas_used"` // 区块中累计消耗的燃料
+	EffectiveGasPrice string `json:"effective_gas_price"` // 实际支付的燃料单价
+	ContractAddress   string `json:"contract_address"`    // 创建合约时新合约的地址
+	Logs              string `xorm:"text" json:"logs"`    // 合约事件日志，json 格式
+}
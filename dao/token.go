@@ -0,0 +1,12 @@
+package dao
+
+// 存储 ERC20 代币信息的结构体，数据来自合约的 name、symbol、decimals 和 totalSupply 函数
+type Token struct {
+	Id              int64  `json:"id"`                             // 主键
+	ContractAddress string `xorm:"unique" json:"contract_address"` // 小写的代币合约地址
+	Name            string `json:"name"`                           // 代币名称，合约没有实现时为空
+	Symbol          string `json:"symbol"`                         // 代币符号，兼容返回 bytes32 的合约
+	Decimals        int    `json:"decimals"`                       // 代币单位精确到小数点后的位数
+	TotalSupply     string `json:"total_supply"`                   // 获取代币信息时的总发行量，十进制，没有除以 decimal
+	UpdateTime      int64  `json:"update_time"`                    // 获取代币信息的时间戳，单位为秒
+}
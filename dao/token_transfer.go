@@ -0,0 +1,15 @@
+package dao
+
+// 存储 ERC20 代币转账记录的结构体，数据来自 Transfer 事件日志
+type TokenTransfer struct {
+	Id           int64  `json:"id"`            // 主键
+	TokenAddress string `json:"token_address"` // 代币的合约地址
+	FromAddress  string `json:"from_address"`  // 转出地址
+	ToAddress    string `json:"to_address"`    // 转入地址
+	Amount       string `json:"amount"`        // 转账数值，十进制，没有除以代币的 decimal
+	LogIndex     int64  `json:"log_index"`     // 日志在区块中的下标
+	TxHash       string `json:"tx_hash"`       // 所在交易的哈希值
+	BlockHash    string `json:"block_hash"`    // 所在区块的哈希值
+	BlockNumber  int64  `json:"block_number"`  // 所在区块的区块号，保存为 BIGINT，按照数值比较区块号范围
+	Fork         bool   `json:"fork"`          // 是否为分叉区块中的转账
+}
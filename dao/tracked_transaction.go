@@ -0,0 +1,23 @@
+package dao
+
+// 存储已经广播的交易的结构体，交易追踪器根据它跟踪交易的状态
+type TrackedTransaction struct {
+	Id          int64  `json:"id"`                        // 主键
+	Hash        string `xorm:"unique" json:"hash"`        // 交易的哈希值
+	FromAddress string `xorm:"index" json:"from_address"` // 交易发起地址
+	ToAddress   string `json:"to_address"`                // 交易接收地址，创建合约时为空
+	Nonce       uint64 `json:"nonce"`                     // 交易的 nonce
+	TxType      int    `json:"tx_type"`                   // 交易类型，0 是传统交易，2 是 EIP-1559 交易
+	Value       string `json:"value"`                     // 转账数值，十进制，单位 wei
+	Gas         uint64 `json:"gas"`                       // gasLimit
+	GasPrice    string `json:"gas_price"`                 // 传统交易的燃料单价，十进制
+	GasFeeCap   string `json:"gas_fee_cap"`               // EIP-1559 交易的 maxFeePerGas，十进制
+	GasTipCap   string `json:"gas_tip_cap"`               // EIP-1559 交易的 maxPriorityFeePerGas，十进制
+	RawTx       string `xorm:"text" json:"raw_tx"`        // 签名后的交易数据，十六进制
+	Status      string `xorm:"index" json:"status"`       // pending、mined、failed、dropped、replaced
+	BlockNumber string `json:"block_number"`              // 被打包的区块号，十进制
+	ReplacedBy  string `json:"replaced_by"`               // 被同一个 nonce 的哪笔交易替换
+	MissingTime int64  `json:"missing_time"`              // 第一次发现交易不在节点交易池中的时间戳，在交易池中时为 0
+	CreateTime  int64  `json:"create_time"`               // 广播的时间戳，单位为秒
+	UpdateTime  int64  `json:"update_time"`               // 最近一次更新状态的时间戳，单位为秒
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"eth-relay/dao"
+	"eth-relay/model"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ERC20 标准 Transfer(address,address,uint256) 事件签名的哈希值，也就是日志的第一个 topic
+var erc20TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)")).Hex()
+
+// 从事件日志中解析 ERC20 转账，不是 ERC20 的 Transfer 事件时第二个返回值为 false
+// ERC721 的 Transfer 事件签名相同，但是 tokenId 也是 indexed 的，有 4 个 topic，需要排除
+func decodeERC20Transfer(log model.Log) (dao.TokenTransfer, bool) {
+	if len(log.Topics) != 3 || !strings.EqualFold(log.Topics[0], erc20TransferTopic) {
+		return dao.TokenTransfer{}, false
+	}
+	data := common.FromHex(log.Data)
+	if len(data) != 32 {
+		return dao.TokenTransfer{}, false
+	}
+	logIndex, _ := new(big.Int).SetString(strings.TrimPrefix(log.LogIndex, "0x"), 16)
+	if logIndex == nil {
+		logIndex = new(big.Int)
+	}
+	blockNumber, _ := new(big.Int).SetString(strings.TrimPrefix(log.BlockNumber, "0x"), 16)
+	if blockNumber == nil {
+		blockNumber = new(big.Int)
+	}
+	return dao.TokenTransfer{
+		TokenAddress: strings.ToLower(log.Address),
+		// indexed 的 address 参数左补 0 到 32 字节，取最后 20 个字节
+		FromAddress: strings.ToLower(common.HexToAddress(log.Topics[1]).Hex()),
+		ToAddress:   strings.ToLower(common.HexToAddress(log.Topics[2]).Hex()),
+		Amount:      new(big.Int).SetBytes(data).String(),
+		LogIndex:    logIndex.Int64(),
+		TxHash:      log.TransactionHash,
+		BlockHash:   log.BlockHash,
+		BlockNumber: blockNumber.Int64(),
+		Fork:        false,
+	}, true
+}
+
+// 从区块所有交易的收据中解析出 ERC20 转账记录
+func decodeERC20Transfers(receipts []*model.Receipt) []dao.TokenTransfer {
+	transfers := []dao.TokenTransfer{}
+	for _, receipt := range receipts {
+		if !receipt.Succeeded() {
+			continue // 执行失败的交易不会产生日志
+		}
+		for _, log := range receipt.Logs {
+			if transfer, ok := decodeERC20Transfer(log); ok {
+				transfers = append(transfers, transfer)
+			}
+		}
+	}
+	return transfers
+}
@@ -1,25 +1,448 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"eth-relay/model"
 	"eth-relay/tool"
 	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// 测试用的以太坊节点服务，注册为 eth 命名空间后提供 eth_blockNumber
+type testEthService struct {
+	number   uint64
+	receipts map[string]*model.Receipt
+	logs     []model.Log             // 按照区块号排序的日志
+	maxLogs  int                     // 每次 eth_getLogs 最多返回的日志数量，0 代表不限制
+	heads    chan *model.BlockHeader // 写入后推送给 newHeads 的订阅者
+	nonces   map[string]uint64       // 地址 pending 状态的 nonce
+	sendErrs []string                // eth_sendRawTransaction 依次返回的错误
+	delays   []time.Duration         // eth_sendRawTransaction 依次在收下交易之前等待的时间，模拟广播超时
+	sent     []uint64                // 广播成功的交易的 nonce
+	lastTx   *types.Transaction      // 最后一笔广播成功的交易
+	chainId  uint64
+	baseFees []string                 // eth_feeHistory 返回的 baseFeePerGas
+	rewards  [][]string               // eth_feeHistory 返回的 reward
+	gas      uint64                   // eth_estimateGas 的结果
+	revert   string                   // 不为空时 eth_estimateGas 返回 revert 错误
+	price    uint64                   // eth_gasPrice 的结果
+	mined    map[string]uint64        // 地址 latest 状态的 nonce
+	pool     map[string]bool          // 交易池中的交易哈希，不为 nil 时记录广播成功的交易
+	call     hexutil.Bytes            // eth_call 的结果
+	calls    map[string]hexutil.Bytes // 不为 nil 时按照 methodId 返回 eth_call 的结果，没有的函数 revert
+	callArg  map[string]interface{}
+	block    string // 最后一次状态查询使用的区块参数，json 格式
+	balance  uint64 // eth_getBalance 的结果
+	onNonce  func() // 不为 nil 时在 eth_getTransactionCount 返回之前调用，模拟两次查询之间节点状态的变化
+}
+
+// 合约执行失败的错误，和节点一样带有 revert 的数据
+type testRevertError struct {
+	data string
+}
+
+func (e *testRevertError) Error() string          { return "execution reverted" }
+func (e *testRevertError) ErrorCode() int         { return 3 }
+func (e *testRevertError) ErrorData() interface{} { return e.data }
+
+func (s *testEthService) EstimateGas(arg map[string]interface{}) (hexutil.Uint64, error) {
+	if s.revert != "" {
+		return 0, &testRevertError{data: s.revert}
+	}
+	return hexutil.Uint64(s.gas), nil
+}
+
+// revert 不为空时和 eth_estimateGas 一样返回 revert 错误
+func (s *testEthService) Call(arg map[string]interface{}, block json.RawMessage) (hexutil.Bytes, error) {
+	s.callArg, s.block = arg, string(block)
+	if s.revert != "" {
+		return nil, &testRevertError{data: s.revert}
+	}
+	if s.calls != nil {
+		data, _ := arg["data"].(string)
+		if len(data) < 10 || s.calls[data[:10]] == nil {
+			return nil, &testRevertError{data: "0x"}
+		}
+		return s.calls[data[:10]], nil
+	}
+	return s.call, nil
+}
+
+func (s *testEthService) GasPrice() *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int).SetUint64(s.price))
+}
+
+func (s *testEthService) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(s.number)
+}
+
+// 生成的区块只有区块号和哈希值，超过最新区块号时和节点一样返回 null
+func (s *testEthService) GetBlockByNumber(number hexutil.Uint64, full bool) *model.FullBlock {
+	if uint64(number) > s.number {
+		return nil
+	}
+	return &model.FullBlock{
+		Number: number.String(),
+		Hash:   fmt.Sprintf("%#064x", uint64(number)),
+	}
+}
+
+func (s *testEthService) GetBalance(address string, block json.RawMessage) *hexutil.Big {
+	s.block = string(block)
+	return (*hexutil.Big)(new(big.Int).SetUint64(s.balance))
+}
+
+func (s *testEthService) GetTransactionCount(address string, block json.RawMessage) hexutil.Uint64 {
+	s.block = string(block)
+	if s.onNonce != nil {
+		s.onNonce()
+	}
+	if s.block == `"latest"` {
+		return hexutil.Uint64(s.mined[address])
+	}
+	return hexutil.Uint64(s.nonces[address])
+}
+
+// 只能查到交易池中的交易，其它交易和节点一样返回 null
+func (s *testEthService) GetTransactionByHash(hash string) *model.Transaction {
+	if !s.pool[hash] {
+		return nil
+	}
+	return &model.Transaction{Hash: hash}
+}
+
+func (s *testEthService) SendRawTransaction(data hexutil.Bytes) (common.Hash, error) {
+	transaction := new(types.Transaction)
+	if err := transaction.UnmarshalBinary(data); err != nil {
+		return common.Hash{}, err
+	}
+	if len(s.delays) > 0 {
+		delay := s.delays[0]
+		s.delays = s.delays[1:]
+		time.Sleep(delay)
+	}
+	if len(s.sendErrs) > 0 {
+		message := s.sendErrs[0]
+		s.sendErrs = s.sendErrs[1:]
+		return common.Hash{}, errors.New(message)
+	}
+	s.sent = append(s.sent, transaction.Nonce())
+	s.lastTx = transaction
+	if s.pool != nil {
+		s.pool[transaction.Hash().Hex()] = true
+	}
+	return transaction.Hash(), nil
+}
+
+func (s *testEthService) ChainId() hexutil.Uint64 {
+	return hexutil.Uint64(s.chainId)
+}
+
+func (s *testEthService) FeeHistory(blockCount hexutil.Uint64, lastBlock string, percentiles []float64) *model.FeeHistory {
+	return &model.FeeHistory{OldestBlock: "0x1", BaseFeePerGas: s.baseFees, Reward: s.rewards}
+}
+
+// newHeads 订阅，把写入 heads 的区块头推送给订阅者
+func (s *testEthService) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	go func() {
+		for {
+			select {
+			case header := <-s.heads:
+				notifier.Notify(sub.ID, header)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (s *testEthService) GetTransactionReceipt(hash string) *model.Receipt {
+	return s.receipts[hash]
+}
+
+func (s *testEthService) GetLogs(filter map[string]interface{}) ([]model.Log, error) {
+	// 没有指定的区块号和节点一样代表 latest
+	from, to := s.number, s.number
+	if value, ok := filter["fromBlock"].(string); ok {
+		from, _ = hexutil.DecodeUint64(value)
+	}
+	if value, ok := filter["toBlock"].(string); ok {
+		to, _ = hexutil.DecodeUint64(value)
+	}
+	logs := []model.Log{}
+	for _, log := range s.logs {
+		number, _ := hexutil.DecodeUint64(log.BlockNumber)
+		if number >= from && number <= to {
+			logs = append(logs, log)
+		}
+	}
+	if s.maxLogs > 0 && len(logs) > s.maxLogs {
+		return nil, fmt.Errorf("query returned more than %d results", s.maxLogs)
+	}
+	return logs, nil
+}
+
+// 测试用的慢节点，每次请求都要很久才返回
+type testSlowService struct{}
+
+func (s *testSlowService) BlockNumber() hexutil.Uint64 {
+	time.Sleep(2 * time.Second)
+	return 1
+}
+
+// 测试用的故障节点，eth_blockNumber 返回格式错误的区块号
+type testBadNumberService struct {
+	number string
+}
+
+func (s *testBadNumberService) BlockNumber() string {
+	return s.number
+}
+
+// 实例化测试用的请求者，失败时终止当前测试
+func newTestRequester(t *testing.T, nodeUrl string) *ETHRPCRequester {
+	requester, err := NewETHRPCRequester(nodeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(requester.Close)
+	return requester
+}
+
+// 在临时目录中创建并解锁一个测试钱包，测试结束后恢复全局的解锁状态
+func unlockTestAccount(t *testing.T) string {
+	ks := keystore.NewKeyStore(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	oldKs, oldMap := tool.UnlockKs, tool.ETHUnlockMap
+	t.Cleanup(func() {
+		tool.UnlockKs, tool.ETHUnlockMap = oldKs, oldMap
+	})
+	address := account.Address.String()
+	tool.UnlockKs = ks
+	tool.ETHUnlockMap = map[string]accounts.Account{address: account}
+	return address
+}
+
+// 在临时目录的 unix socket 上启动一个进程内的 rpc 节点，返回 socket 路径
+func startTestIPCNode(t *testing.T, service interface{}) string {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "eth-relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := filepath.Join(dir, "geth.ipc")
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeListener(listener)
+	t.Cleanup(func() {
+		listener.Close()
+		server.Stop()
+		os.RemoveAll(dir)
+	})
+	return endpoint
+}
+
 func TestNewETHRPCClient(t *testing.T) {
 	// 首先是一个格式正确的链接测试初始化
-	client2 := NewETHRPCClient("www.nihao.com").GetRpc()
-	if client2 == nil {
-		fmt.Println("初始化失败")
+	client2, err := NewETHRPCClient("www.nihao.com")
+	if err != nil {
+		fmt.Println("初始化失败", err.Error())
+	} else {
+		fmt.Println("初始化成功", client2.Transport())
 	}
 	// 接着是 123://356 非法链接测试初始化
-	client := NewETHRPCClient("123://456").GetRpc()
-	if client == nil {
-		fmt.Println("初始化失败")
+	_, err = NewETHRPCClient("123://456")
+	if !errors.Is(err, ErrDialFailed) {
+		t.Fatal("非法链接应该返回 ErrDialFailed")
+	}
+	fmt.Println("初始化失败", err.Error())
+}
+
+// 单元测试：根据链接的协议选择传输方式
+func Test_ParseTransport(t *testing.T) {
+	cases := map[string]Transport{
+		"https://mainnet.infura.io/v3/xxx": TransportHTTP,
+		"http://127.0.0.1:8545":            TransportHTTP,
+		"ws://127.0.0.1:8546":              TransportWebSocket,
+		"wss://mainnet.infura.io/ws/v3/x":  TransportWebSocket,
+		"unix:///data/geth.ipc":            TransportIPC,
+		"/data/geth.ipc":                   TransportIPC,
+		"./geth.ipc":                       TransportIPC,
+	}
+	for nodeUrl, want := range cases {
+		if got, _ := parseTransport(nodeUrl); got != want {
+			t.Fatalf("%s 的传输方式应该是 %s，实际是 %s", nodeUrl, want, got)
+		}
+	}
+}
+
+// 单元测试：通过 ipc 连接本地节点，同一个节点共用一个 rpc 句柄
+func Test_NewETHRPCClientIPC(t *testing.T) {
+	endpoint := startTestIPCNode(t, &testEthService{number: 100})
+	client1, err := NewETHRPCClient(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client2, err := NewETHRPCClient("unix://" + endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+	defer client2.Close()
+	if client1.Transport() != TransportIPC || !client1.SupportsSubscription() {
+		t.Fatal("应该使用 ipc 传输方式")
+	}
+	if client1.GetRpc() != client2.GetRpc() {
+		t.Fatal("同一个节点应该共用一个 rpc 句柄")
+	}
+	number := hexutil.Uint64(0)
+	if err := client1.GetRpc().Call(&number, "eth_blockNumber"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("ipc 获取区块号：", uint64(number))
+}
+
+// 单元测试：节点池选择最健康的节点，节点故障时自动切换
+func Test_ETHRPCClientPool(t *testing.T) {
+	lagging := startTestIPCNode(t, &testEthService{number: 100})
+	latest := startTestIPCNode(t, &testEthService{number: 200})
+	pool, err := NewETHRPCClientPool([]NodeConfig{
+		{Url: lagging, Priority: 0},
+		{Url: latest, Priority: 1},
+		{Url: "/not/exist/geth.ipc", Priority: 0}, // 连接不上的节点
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.CheckHealth()
+	// 优先级高的节点落后了 100 个区块，应该选择区块最新的节点
+	number := hexutil.Uint64(0)
+	if err := pool.Call(&number, "eth_blockNumber"); err != nil {
+		t.Fatal(err)
+	}
+	if number != 200 {
+		t.Fatalf("应该请求区块最新的节点，实际区块号是 %d", number)
+	}
+	for _, status := range pool.Status() {
+		fmt.Println(status.Url, "healthy:", status.Healthy, "head:", status.Head, "lag:", status.Lag)
+	}
+	// 关闭区块最新的节点，请求应该自动切换到剩下的节点
+	pool.GetRpc().Close()
+	if err := pool.Call(&number, "eth_blockNumber"); err != nil {
+		t.Fatal(err)
+	}
+	if number != 100 {
+		t.Fatalf("应该切换到剩下的节点，实际区块号是 %d", number)
+	}
+}
+
+// 单元测试：节点返回格式错误的区块号时标记为不健康，健康检查不会 panic
+func Test_ETHRPCClientPoolBadBlockNumber(t *testing.T) {
+	healthy := startTestIPCNode(t, &testEthService{number: 100})
+	configs := []NodeConfig{{Url: healthy, Priority: 1}}
+	for _, number := range []string{"0xzz", "64", "", "0x"} {
+		configs = append(configs, NodeConfig{Url: startTestIPCNode(t, &testBadNumberService{number: number}), Priority: 0})
+	}
+	pool, err := NewETHRPCClientPool(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.CheckHealth()
+	for _, status := range pool.Status() {
+		if status.Healthy != (status.Url == healthy) {
+			t.Fatalf("节点 %s 的健康状态错误 %v", status.Url, status.LastError)
+		}
+	}
+	number := hexutil.Uint64(0)
+	if err := pool.Call(&number, "eth_blockNumber"); err != nil || number != 100 {
+		t.Fatalf("应该请求健康的节点 %d %v", number, err)
 	}
 }
 
+// 单元测试：所有节点都失败时，返回的错误仍然可以用 errors.Is 判断原因
+func Test_ETHRPCClientPoolErrorChain(t *testing.T) {
+	pool, err := NewETHRPCClientPool([]NodeConfig{
+		{Url: startTestIPCNode(t, &testEthService{number: 100}), Priority: 0},
+		{Url: "/not/exist/geth.ipc", Priority: 1}, // 连接不上的节点
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.GetRpc().Close()
+	number := hexutil.Uint64(0)
+	if err := pool.Call(&number, "eth_blockNumber"); !errors.Is(err, ErrDialFailed) {
+		t.Fatalf("应该包含 ErrDialFailed %v", err)
+	}
+
+	single, err := NewETHRPCClientPool([]NodeConfig{{Url: startTestIPCNode(t, &testEthService{number: 100})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer single.Close()
+	single.GetRpc().Close()
+	if err := single.Call(&number, "eth_blockNumber"); !errors.Is(err, rpc.ErrClientQuit) {
+		t.Fatalf("应该包含 rpc.ErrClientQuit %v", err)
+	}
+}
+
+// 单元测试：慢节点不会让调用方一直阻塞
+func Test_RequesterTimeout(t *testing.T) {
+	requester := newTestRequester(t, startTestIPCNode(t, &testSlowService{}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := requester.GetLatestBlockNumberContext(ctx); err == nil {
+		t.Fatal("超时的请求应该返回错误")
+	}
+	// 不带 Context 的版本使用默认超时
+	requester.SetDefaultTimeout(100 * time.Millisecond)
+	_, err := requester.GetLatestBlockNumber()
+	if err == nil {
+		t.Fatal("超时的请求应该返回错误")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("请求没有按时超时")
+	}
+	fmt.Println("请求超时：", err.Error())
+}
+
 func Test_GetTransactionByHash(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
 	txHash := "0xd34279f67e05c398a863177b73b735a6141deba3bda62342a8f2c91f36a22f8e"
@@ -28,7 +451,7 @@ func Test_GetTransactionByHash(t *testing.T) {
 		fmt.Println("非法的交易哈希值")
 		return
 	}
-	txInfo, err := NewETHRPCRequester(nodeUrl).GetTransactionByHash(txHash)
+	txInfo, err := newTestRequester(t, nodeUrl).GetTransactionByHash(txHash)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询交易失败，信息是：", err.Error())
@@ -54,7 +477,7 @@ func Test_GetTransactions(t *testing.T) {
 		fmt.Println("非法的交易哈希值数组")
 		return
 	}
-	txInfos, err := NewETHRPCRequester(nodeUrl).GetTransactions(txHashs)
+	txInfos, err := newTestRequester(t, nodeUrl).GetTransactions(txHashs)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询交易失败，信息是：", err.Error())
@@ -65,6 +488,79 @@ func Test_GetTransactions(t *testing.T) {
 	fmt.Println(string(json))
 }
 
+// 单元测试：获取交易收据，没有被打包的交易返回 ErrReceiptNotFound
+func Test_GetTransactionReceipt(t *testing.T) {
+	txHash := "0xd34279f67e05c398a863177b73b735a6141deba3bda62342a8f2c91f36a22f8e"
+	pending := "0x52a1dc843a9918b76e71334a034d46e4cf4834bcfa2409bc7286baa5bca91eed"
+	service := &testEthService{receipts: map[string]*model.Receipt{
+		txHash: {TransactionHash: txHash, Status: "0x1", GasUsed: "0x5208"},
+	}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	receipt, err := requester.GetTransactionReceipt(txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !receipt.Succeeded() {
+		t.Fatal("交易应该执行成功")
+	}
+	if _, err := requester.GetTransactionReceipt(pending); !errors.Is(err, ErrReceiptNotFound) {
+		t.Fatal("没有被打包的交易应该返回 ErrReceiptNotFound")
+	}
+	receipts, err := requester.GetTransactionReceipts([]string{txHash, pending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipts[0] == nil || receipts[1] != nil {
+		t.Fatal("批量获取的收据和交易哈希值不对应")
+	}
+	json, _ := json.Marshal(receipts)
+	fmt.Println(string(json))
+}
+
+// 单元测试：批量获取主网上的交易收据
+func Test_GetTransactionReceipts(t *testing.T) {
+	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
+	txHash_1 := "0xd34279f67e05c398a863177b73b735a6141deba3bda62342a8f2c91f36a22f8e"
+	txHash_2 := "0x52a1dc843a9918b76e71334a034d46e4cf4834bcfa2409bc7286baa5bca91eed"
+	receipts, err := newTestRequester(t, nodeUrl).GetTransactionReceipts([]string{txHash_1, txHash_2})
+	if err != nil {
+		// 查询失败，打印出信息
+		fmt.Println("查询交易收据失败，信息是：", err.Error())
+		return
+	}
+	json, _ := json.Marshal(receipts)
+	fmt.Println(string(json))
+}
+
+// 单元测试：结果太多时自动拆分区块范围获取事件日志
+func Test_GetLogs(t *testing.T) {
+	service := &testEthService{number: 10, maxLogs: 3}
+	for i := uint64(1); i <= 10; i++ {
+		service.logs = append(service.logs, model.Log{BlockNumber: hexutil.EncodeUint64(i)})
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	filter := model.LogFilter{
+		FromBlock: big.NewInt(1),
+		Addresses: []string{"0xdAC17F958D2ee523a2206206994597C13D831ec7"},
+		// Transfer 事件，from 为任意地址
+		Topics: [][]string{{"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"}, {}},
+	}
+	logs, err := requester.GetLogs(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 10 {
+		t.Fatalf("应该获取到 10 条日志，实际是 %d 条", len(logs))
+	}
+	for i, log := range logs {
+		if log.BlockNumber != hexutil.EncodeUint64(uint64(i+1)) {
+			t.Fatal("日志的顺序不对")
+		}
+	}
+	data, _ := json.Marshal(filter)
+	fmt.Println(string(data))
+}
+
 // 单笔交易的单元测试函数
 func Test_GetETHBalance(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
@@ -74,7 +570,7 @@ func Test_GetETHBalance(t *testing.T) {
 		fmt.Println("非法的交易地址值")
 		return
 	}
-	balance, err := NewETHRPCRequester(nodeUrl).GetETHBalance(address)
+	balance, err := newTestRequester(t, nodeUrl).GetETHBalance(address)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询 eth 余额失败，信息是：", err.Error())
@@ -92,7 +588,7 @@ func Test_GetETHBalances(t *testing.T) {
 
 	addresss := []string{address1, address2}
 
-	balances, err := NewETHRPCRequester(nodeUrl).GetETHBalances(addresss)
+	balances, err := newTestRequester(t, nodeUrl).GetETHBalances(addresss)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询 eth 余额失败，信息是：", err.Error())
@@ -120,7 +616,7 @@ func Test_GetERC20Balances(t *testing.T) {
 	item.ContractAddress = contract2
 	params = append(params, item)
 
-	balance, err := NewETHRPCRequester(nodeUrl).GetERC20Balances(params)
+	balance, err := newTestRequester(t, nodeUrl).GetERC20Balances(params)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询 eth 余额失败，信息是：", err.Error())
@@ -132,7 +628,7 @@ func Test_GetERC20Balances(t *testing.T) {
 // 单元测试，获取以太坊最新生成区块的区块号
 func Test_GetLatestBlockNumber(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
-	number, err := NewETHRPCRequester(nodeUrl).GetLatestBlockNumber()
+	number, err := newTestRequester(t, nodeUrl).GetLatestBlockNumber()
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询区块号失败，信息是：", err.Error())
@@ -144,7 +640,7 @@ func Test_GetLatestBlockNumber(t *testing.T) {
 // 单元测试：根据区块号获取区块信息
 func Test_GetBlockInfoByNunber(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
-	requester := NewETHRPCRequester(nodeUrl)
+	requester := newTestRequester(t, nodeUrl)
 	number, _ := requester.GetLatestBlockNumber() // 获取区块号
 	fmt.Println("区块号是：\n", number)
 	fullBlock, err := requester.GetBlockInfoByNumber(number) // 获取区块信息
@@ -158,10 +654,387 @@ func Test_GetBlockInfoByNunber(t *testing.T) {
 	fmt.Println("根据区块号获取区块信息：\n", string(json1))
 }
 
+// 单元测试：根据区块号数组批量获取区块信息，区块不存在时返回错误
+func Test_GetBlockInfosByNumber(t *testing.T) {
+	requester := newTestRequester(t, startTestIPCNode(t, &testEthService{number: 10}))
+	blocks, err := requester.GetBlockInfosByNumber([]*big.Int{big.NewInt(3), big.NewInt(5), big.NewInt(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || blocks[0].Number != "0x3" || blocks[1].Number != "0x5" || blocks[2].Number != "0x4" {
+		t.Fatalf("区块顺序错误 %+v", blocks)
+	}
+	_, err = requester.GetBlockInfosByNumber([]*big.Int{big.NewInt(9), big.NewInt(11)})
+	if err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Fatalf("区块不存在时应该返回错误 %v", err)
+	}
+}
+
+// 单元测试：按照策略计算 EIP-1559 燃料费，并发送 EIP-1559 类型的交易
+func Test_SendETHTransactionWithFee(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{
+		number:   10,
+		chainId:  5,
+		nonces:   map[string]uint64{},
+		baseFees: []string{"0x64", "0x64", "0xc8"}, // 下一个区块的 baseFee 是 200
+		rewards:  [][]string{{"0x1"}, {"0xa"}},
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	fee, err := requester.SuggestDynamicFee(FeeFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 小费取中位数 10，maxFeePerGas = 200 * 2 + 10
+	if fee.MaxPriorityFeePerGas.Int64() != 10 || fee.MaxFeePerGas.Int64() != 410 {
+		t.Fatalf("燃料费错误 %v %v", fee.MaxFeePerGas, fee.MaxPriorityFeePerGas)
+	}
+	if _, err := requester.SuggestDynamicFee("unknown"); err == nil {
+		t.Fatal("未知的策略应该返回错误")
+	}
+
+	to := "0x3333333333333333333333333333333333333333"
+	if _, err := requester.SendETHTransactionWithFee(address, to, "1", 21000, FeeOption{}); err != nil {
+		t.Fatal(err)
+	}
+	tx := service.lastTx
+	if tx.Type() != types.DynamicFeeTxType || tx.ChainId().Uint64() != 5 || tx.GasFeeCap().Int64() != 310 {
+		t.Fatalf("交易错误 type %d chain %v feeCap %v", tx.Type(), tx.ChainId(), tx.GasFeeCap())
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil || from.String() != address {
+		t.Fatalf("签名错误 %v %v", from, err)
+	}
+	// 直接指定燃料费
+	fee2 := FeeOption{MaxFeePerGas: big.NewInt(1000), MaxPriorityFeePerGas: big.NewInt(2)}
+	if _, err := requester.SendERC20TransactionWithFee(address, to, to, "1", 60000, 18, fee2); err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.GasFeeCap().Int64() != 1000 || service.lastTx.GasTipCap().Int64() != 2 || service.lastTx.Nonce() != 1 {
+		t.Fatalf("交易错误 %v %v", service.lastTx.GasFeeCap(), service.lastTx.GasTipCap())
+	}
+}
+
+// 单元测试：只设置了一个燃料费时，另一个按照策略计算，设置的那个不会被忽略
+func Test_DynamicFeePartialOption(t *testing.T) {
+	service := &testEthService{
+		number:   10,
+		baseFees: []string{"0x64", "0xc8"}, // 下一个区块的 baseFee 是 200
+		rewards:  [][]string{{"0xa"}},
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	ctx := context.Background()
+	// normal 策略建议小费 10，maxFeePerGas = 200 * 1.5 + 10
+	fee, err := requester.dynamicFee(ctx, FeeOption{MaxFeePerGas: big.NewInt(250)})
+	if err != nil || fee.MaxFeePerGas.Int64() != 250 || fee.MaxPriorityFeePerGas.Int64() != 10 {
+		t.Fatalf("燃料费错误 %+v %v", fee, err)
+	}
+	fee, err = requester.dynamicFee(ctx, FeeOption{MaxPriorityFeePerGas: big.NewInt(3)})
+	if err != nil || fee.MaxFeePerGas.Int64() != 303 || fee.MaxPriorityFeePerGas.Int64() != 3 {
+		t.Fatalf("燃料费错误 %+v %v", fee, err)
+	}
+	// 建议的小费超过了设置的 maxFeePerGas
+	if _, err := requester.dynamicFee(ctx, FeeOption{MaxFeePerGas: big.NewInt(5)}); err == nil {
+		t.Fatal("小费超过 maxFeePerGas 时应该返回错误")
+	}
+	if _, err := requester.dynamicFee(ctx, FeeOption{MaxFeePerGas: big.NewInt(5), MaxPriorityFeePerGas: big.NewInt(6)}); err == nil {
+		t.Fatal("小费超过 maxFeePerGas 时应该返回错误")
+	}
+}
+
+// 单元测试：chain id 从节点获取后缓存，和配置的不一致时拒绝签名
+func Test_ChainId(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 10, chainId: 5, nonces: map[string]uint64{}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	to := "0x3333333333333333333333333333333333333333"
+	if _, err := requester.SendETHTransaction(address, to, "1", 21000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !service.lastTx.Protected() || service.lastTx.ChainId().Int64() != 5 {
+		t.Fatalf("没有使用 EIP-155 签名 %v", service.lastTx.ChainId())
+	}
+	// 已经缓存，不再请求节点
+	service.chainId = 1
+	if chainId, err := requester.ChainId(); err != nil || chainId.Int64() != 5 {
+		t.Fatalf("chain id 错误 %v %v", chainId, err)
+	}
+	// 配置的 chain id 和节点不一致
+	requester.SetChainId(big.NewInt(5))
+	if _, err := requester.SendETHTransaction(address, to, "1", 21000, 1); !errors.Is(err, ErrChainIdMismatch) {
+		t.Fatalf("应该返回 ErrChainIdMismatch %v", err)
+	}
+	if len(service.sent) != 1 {
+		t.Fatalf("拒绝签名的交易不应该被广播 %v", service.sent)
+	}
+}
+
+// 单元测试：缓存 chain id 之后节点池切换节点，其它链的节点被标记为不健康，交易不会发送给它
+func Test_ChainIdFailover(t *testing.T) {
+	address := unlockTestAccount(t)
+	primary := &testEthService{number: 10, chainId: 5, nonces: map[string]uint64{}}
+	other := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{}}
+	backup := &testEthService{number: 10, chainId: 5, nonces: map[string]uint64{}}
+	pool, err := NewETHRPCClientPool([]NodeConfig{
+		{Url: startTestIPCNode(t, primary), Priority: 0},
+		{Url: startTestIPCNode(t, other), Priority: 1},
+		{Url: startTestIPCNode(t, backup), Priority: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requester := NewETHRPCRequesterWithPool(pool)
+	defer requester.Close()
+	pool.CheckHealth()
+	if chainId, err := requester.ChainId(); err != nil || chainId.Int64() != 5 {
+		t.Fatalf("chain id 错误 %v %v", chainId, err)
+	}
+	// 主节点故障，优先级次高的节点在另一条链上
+	pool.GetRpc().Close()
+	pool.CheckHealth()
+	for _, status := range pool.Status() {
+		fmt.Println(status.Url, "healthy:", status.Healthy, status.LastError)
+	}
+	if status := pool.Status()[1]; status.Healthy || !errors.Is(status.LastError, ErrChainIdMismatch) {
+		t.Fatalf("其它链的节点应该是不健康的 %v %v", status.Healthy, status.LastError)
+	}
+	to := "0x3333333333333333333333333333333333333333"
+	if _, err := requester.SendETHTransaction(address, to, "1", 21000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(other.sent) != 0 || len(backup.sent) != 1 || backup.lastTx.ChainId().Int64() != 5 {
+		t.Fatalf("交易应该发送给同一条链的节点 %v %v", other.sent, backup.sent)
+	}
+}
+
+// 单元测试：设置 chain id 后立即检查，不用等到下一轮健康检查，其它链的节点不会被选中
+func Test_ETHRPCClientPoolSetChainId(t *testing.T) {
+	pool, err := NewETHRPCClientPool([]NodeConfig{
+		{Url: startTestIPCNode(t, &testEthService{number: 10, chainId: 1}), Priority: 0},
+		{Url: startTestIPCNode(t, &testEthService{number: 10, chainId: 5}), Priority: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.SetChainId(big.NewInt(5))
+	if status := pool.Status()[0]; status.Healthy || !errors.Is(status.LastError, ErrChainIdMismatch) {
+		t.Fatalf("其它链的节点应该是不健康的 %v %v", status.Healthy, status.LastError)
+	}
+	chainId := hexutil.Uint64(0)
+	if err := pool.Call(&chainId, "eth_chainId"); err != nil || chainId != 5 {
+		t.Fatalf("应该请求同一条链的节点 %d %v", chainId, err)
+	}
+}
+
+// 单元测试：自动估算燃料，估算失败时在签名之前返回
+func Test_EstimateGas(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{}, gas: 50000, price: 7}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	token := "0x4444444444444444444444444444444444444444"
+	to := "0x3333333333333333333333333333333333333333"
+	if _, err := requester.SendERC20Transaction(address, token, to, "1", 0, 0, 18); err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.Gas() != 60000 || service.lastTx.GasPrice().Int64() != 7 {
+		t.Fatalf("燃料错误 %d %v", service.lastTx.Gas(), service.lastTx.GasPrice())
+	}
+	// 乘上安全系数后超过上限时使用上限
+	requester.SetGasCap(token, 55000)
+	if _, err := requester.SendERC20Transaction(address, token, to, "1", 0, 0, 18); err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.Gas() != 55000 {
+		t.Fatalf("燃料上限错误 %d", service.lastTx.Gas())
+	}
+	// 估算结果本身超过上限
+	requester.SetGasCap(token, 40000)
+	if _, err := requester.SendERC20Transaction(address, token, to, "1", 0, 0, 18); !errors.Is(err, ErrGasCapExceeded) {
+		t.Fatalf("应该返回 ErrGasCapExceeded %v", err)
+	}
+	// 合约执行会失败
+	service.revert = "0x08c379a0"
+	_, err := requester.SendERC20TransactionWithFee(address, token, to, "1", 0, 18, FeeOption{MaxFeePerGas: big.NewInt(2), MaxPriorityFeePerGas: big.NewInt(1)})
+	var estimationErr *GasEstimationError
+	if !errors.As(err, &estimationErr) || estimationErr.Data != "0x08c379a0" {
+		t.Fatalf("应该返回 GasEstimationError %v", err)
+	}
+	// 估算失败的交易没有占用 nonce
+	service.revert = ""
+	if _, err := requester.SendETHTransaction(address, to, "1", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(service.sent) != "[0 1 2]" {
+		t.Fatalf("广播的 nonce 错误 %v", service.sent)
+	}
+}
+
+// 单元测试：按照 abi 打包入参、解析返回值，调用合约函数的交易走签名和 nonce 管理器
+func Test_Contract(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{}, gas: 50000, price: 7}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	contractABI := `[
+	{"constant": true, "inputs": [{"name": "owner", "type": "address"}], "name": "balanceOf",
+	"outputs": [{"name": "", "type": "uint256"}], "stateMutability": "view", "type": "function"},
+	{"constant": false, "inputs": [{"name": "to", "type": "address"}, {"name": "value", "type": "uint256"}],
+	"name": "transfer", "outputs": [{"name": "", "type": "bool"}], "stateMutability": "nonpayable", "type": "function"}]`
+	token := "0x4444444444444444444444444444444444444444"
+	contract, err := NewContract(requester, token, contractABI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	// 只读函数，返回值按照 abi 解析为 *big.Int
+	service.call = common.LeftPadBytes(big.NewInt(1000).Bytes(), 32)
+	values, err := contract.Call(PendingBlock, "balanceOf", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, ok := values[0].(*big.Int); !ok || balance.Int64() != 1000 {
+		t.Fatalf("返回值错误 %v", values)
+	}
+	if service.block != `"pending"` || service.callArg["data"] != "0x70a08231"+common.Bytes2Hex(common.LeftPadBytes(owner.Bytes(), 32)) {
+		t.Fatalf("eth_call 参数错误 %s %v", service.block, service.callArg)
+	}
+	// 入参类型错误时不请求节点
+	if _, err := contract.Call(LatestBlock, "balanceOf", "not an address"); err == nil {
+		t.Fatal("入参类型错误时应该返回错误")
+	}
+	// 地址上没有合约
+	service.call = nil
+	if _, err := contract.Call(LatestBlock, "balanceOf", owner); err == nil {
+		t.Fatal("没有返回数据时应该返回错误")
+	}
+	// revert 的原因从 Error(string) 中解析
+	service.revert = "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000"
+	_, err = contract.Call(LatestBlock, "balanceOf", owner)
+	var callErr *ContractCallError
+	if !errors.As(err, &callErr) || callErr.Reason != "nope" {
+		t.Fatalf("应该返回 ContractCallError %v", err)
+	}
+
+	// 发送交易
+	service.revert = ""
+	if _, err := contract.Transact(address, "transfer", owner, big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := contract.Pack("transfer", owner, big.NewInt(5))
+	if *service.lastTx.To() != contract.Address() || !bytes.Equal(service.lastTx.Data(), data) || service.lastTx.Gas() != 60000 {
+		t.Fatalf("合约交易错误 %v %x", service.lastTx.To(), service.lastTx.Data())
+	}
+	if _, err := contract.TransactWithFee(address, FeeOption{MaxFeePerGas: big.NewInt(2), MaxPriorityFeePerGas: big.NewInt(1)}, "transfer", owner, big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.Type() != types.DynamicFeeTxType || fmt.Sprint(service.sent) != "[0 1]" {
+		t.Fatalf("合约交易错误 %d %v", service.lastTx.Type(), service.sent)
+	}
+}
+
+// 单元测试：区块参数的编码，以及查询状态的函数使用指定的区块
+func Test_BlockTag(t *testing.T) {
+	hash := "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	tags := map[string]BlockTag{
+		`"latest"`:    {},
+		`"pending"`:   PendingBlock,
+		`"safe"`:      SafeBlock,
+		`"finalized"`: FinalizedBlock,
+		`"earliest"`:  EarliestBlock,
+		`"0x0"`:       BlockAtNumber(big.NewInt(0)),
+		`"0x3e8"`:     BlockAtNumber(big.NewInt(1000)),
+		`{"blockHash":"` + hash + `","requireCanonical":true}`: BlockAtHash(hash, true),
+	}
+	for expected, tag := range tags {
+		data, err := json.Marshal(tag)
+		if err != nil || string(data) != expected {
+			t.Fatalf("编码错误 %s %v，应该是 %s", data, err, expected)
+		}
+	}
+	if _, err := json.Marshal(BlockAtNumber(big.NewInt(-1))); err == nil {
+		t.Fatal("负数的区块号应该返回错误")
+	}
+
+	service := &testEthService{number: 10, balance: 5, nonces: map[string]uint64{}, mined: map[string]uint64{}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	address := "0x3333333333333333333333333333333333333333"
+	if balance, err := requester.GetETHBalanceAt(address, BlockAtNumber(big.NewInt(8))); err != nil || balance != "5" || service.block != `"0x8"` {
+		t.Fatalf("查询余额错误 %s %v %s", balance, err, service.block)
+	}
+	if _, err := requester.GetETHBalancesAt([]string{address}, BlockAtHash(hash, false)); err != nil || service.block != `{"blockHash":"`+hash+`","requireCanonical":false}` {
+		t.Fatalf("批量查询余额错误 %v %s", err, service.block)
+	}
+	if _, err := requester.GetETHBalance(address); err != nil || service.block != `"latest"` {
+		t.Fatalf("默认应该查询 latest %v %s", err, service.block)
+	}
+	service.call = common.LeftPadBytes(big.NewInt(7).Bytes(), 32)
+	params := []ERC20BalanceRpcReq{{ContractAddress: "0x4444444444444444444444444444444444444444", UserAddress: address}}
+	if balances, err := requester.GetERC20BalancesAt(params, FinalizedBlock); err != nil || balances[0] != "7" || service.block != `"finalized"` {
+		t.Fatalf("查询代币余额错误 %v %v %s", balances, err, service.block)
+	}
+	result := ""
+	if err := requester.ETHCallAt(&result, model.CallArg{To: common.HexToAddress(address)}, SafeBlock); err != nil || service.block != `"safe"` {
+		t.Fatalf("eth_call 错误 %v %s", err, service.block)
+	}
+	service.nonces[address], service.mined[address] = 4, 2
+	if nonce, err := requester.GetNonce(address); err != nil || nonce != 4 {
+		t.Fatalf("默认应该查询 pending %d %v", nonce, err)
+	}
+	if nonce, err := requester.GetNonceAt(address, LatestBlock); err != nil || nonce != 2 {
+		t.Fatalf("查询 latest 的 nonce 错误 %d %v", nonce, err)
+	}
+}
+
+// 单元测试：使用构造函数传入的签名者发送交易，不需要全局解锁钱包
+func Test_RequesterSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := tool.NewPrivateKeySigner(key)
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{}, price: 7}
+	requester, err := NewETHRPCRequester(startTestIPCNode(t, service), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.Close()
+	address := signer.Address().Hex()
+	to := "0x3333333333333333333333333333333333333333"
+	if _, err := requester.SendETHTransaction(strings.ToLower(address), to, "1", 21000, 0); err != nil {
+		t.Fatal(err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), service.lastTx)
+	if err != nil || from != signer.Address() {
+		t.Fatalf("签名者错误 %s %v", from.Hex(), err)
+	}
+	// 构造函数传入的签名者签名时也记录审计日志，调用者是发起签名的请求者
+	logs := &bytes.Buffer{}
+	tool.SetAuditLogger(tool.NewJSONAuditLogger(logs))
+	defer tool.SetAuditLogger(tool.NewJSONAuditLogger(os.Stderr))
+	txHash, err := requester.WithCaller("payout-service").SendETHTransaction(address, to, "1", 21000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := tool.AuditEvent{}
+	if err := json.Unmarshal(logs.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != tool.AuditSign || event.Caller != "payout-service" || event.Address != address ||
+		event.Detail != "transaction "+txHash {
+		t.Fatalf("审计日志错误 %+v", event)
+	}
+	// 移除后没有全局解锁的钱包，签名失败
+	requester.RemoveSigner(address)
+	if _, err := requester.SendETHTransaction(address, to, "1", 21000, 0); err == nil {
+		t.Fatal("没有签名者时应该返回错误")
+	}
+}
+
 // 单元测试：根据区块哈希值获取区块信息
 func Test_GetBlockInfoByHash(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
-	requester := NewETHRPCRequester(nodeUrl)
+	requester := newTestRequester(t, nodeUrl)
 	blockHash := "0xd5310fc253dab0060e3d7ae6d0b88eb72f117e6e9d37a5f7b1ca5250e08249b9"
 	// 根据区块哈希获取区块信息
 	fullBlock, err := requester.GetBlockInfoByHash(blockHash)
@@ -215,14 +1088,14 @@ func Test_GetBlockInfoByHash(t *testing.T) {
 // 单元测试：创建以太坊钱包
 func Test_CreateETHWallet(t *testing.T) {
 	nodeUrl := "https://mainnet.infura.io/v3/70888e737c7b4306aa7f386af25aca71"
-	address1, err := NewETHRPCRequester(nodeUrl).CreateETHWallet("12345")
+	address1, err := newTestRequester(t, nodeUrl).CreateETHWallet("12345")
 	// 演示密码太短的错误
 	if err != nil {
 		fmt.Println("第一次，创建钱包失败", err.Error())
 	} else {
 		fmt.Println("第一次，创建钱包成功，以太坊地址是：", address1)
 	}
-	address2, err := NewETHRPCRequester(nodeUrl).CreateETHWallet("123456")
+	address2, err := newTestRequester(t, nodeUrl).CreateETHWallet("123456")
 	// 创建成功
 	if err != nil {
 		fmt.Println("第二次，创建钱包失败", err.Error())
@@ -240,7 +1113,7 @@ func Test_GetNonce(t *testing.T) {
 		fmt.Println("非法的交易地址值")
 		return
 	}
-	nonce, err := NewETHRPCRequester(nodeUrl).GetNonce(address)
+	nonce, err := newTestRequester(t, nodeUrl).GetNonce(address)
 	if err != nil {
 		// 查询失败，打印出信息
 		fmt.Println("查询 nonce 失败，信息是：", err.Error())
@@ -269,7 +1142,7 @@ func Test_SendETHTransaction(t *testing.T) {
 		return
 	}
 	// 下面发起交易转账
-	txHash, err := NewETHRPCRequester(nodeUrl).SendETHTransaction(from, to, value, gasLimit, gasPrice)
+	txHash, err := newTestRequester(t, nodeUrl).SendETHTransaction(from, to, value, gasLimit, gasPrice)
 	if err != nil {
 		// 转账失败，打印出信息
 		fmt.Println("ETH 转账失败，信息是：", err.Error())
@@ -300,7 +1173,7 @@ func Test_SendERC20Transaction(t *testing.T) {
 		return
 	}
 	// 下面发起交易转账
-	txHash, err := NewETHRPCRequester(nodeUrl).SendERC20Transaction(from, to, receiver, amount, gasLimit, gasPrice, decimal)
+	txHash, err := newTestRequester(t, nodeUrl).SendERC20Transaction(from, to, receiver, amount, gasLimit, gasPrice, decimal)
 	if err != nil {
 		// 转账失败，打印出信息
 		fmt.Println("ETH 转账失败，信息是：", err.Error())
@@ -0,0 +1,9 @@
+package model
+
+// 区块头结构体，newHeads 订阅推送的数据，只解析需要用到的字段
+type BlockHeader struct {
+	Number     string `json:"number"`     // 区块号
+	Hash       string `json:"hash"`       // 区块的哈希值
+	ParentHash string `json:"parentHash"` // 父区块的哈希值
+	Timestamp  string `json:"timestamp"`  // 区块的时间戳，单位为秒
+}
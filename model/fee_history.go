@@ -0,0 +1,9 @@
+package model
+
+// eth_feeHistory 的返回结果
+type FeeHistory struct {
+	OldestBlock   string     `json:"oldestBlock"`   // 第一个区块的区块号
+	BaseFeePerGas []string   `json:"baseFeePerGas"` // 每个区块的 baseFee，最后一个是下一个区块的 baseFee
+	GasUsedRatio  []float64  `json:"gasUsedRatio"`  // 每个区块的燃料使用率
+	Reward        [][]string `json:"reward"`        // 每个区块中按照请求的百分位数统计的小费
+}
@@ -0,0 +1,14 @@
+package model
+
+// Log 合约事件日志结构体
+type Log struct {
+	Address          string   `json:"address"`          // 产生日志的合约地址
+	Topics           []string `json:"topics"`           // 事件的 topic 数组，第一个是事件签名的哈希值
+	Data             string   `json:"data"`             // 没有被 indexed 的事件参数
+	BlockNumber      string   `json:"blockNumber"`      // 日志所在的区块号
+	BlockHash        string   `json:"blockHash"`        // 日志所在区块的哈希值
+	TransactionHash  string   `json:"transactionHash"`  // 产生日志的交易哈希值
+	TransactionIndex string   `json:"transactionIndex"` // 交易在区块中的下标
+	LogIndex         string   `json:"logIndex"`         // 日志在区块中的下标
+	Removed          bool     `json:"removed"`          // 区块分叉后日志被移除时为 true
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// LogFilter 是 eth_getLogs 的过滤条件
+type LogFilter struct {
+	FromBlock *big.Int   // 起始区块号，nil 代表 latest
+	ToBlock   *big.Int   // 结束区块号，nil 代表 latest
+	BlockHash string     // 只查询某一个区块，设置后忽略 FromBlock 和 ToBlock
+	Addresses []string   // 合约地址，满足其中任意一个即可
+	Topics    [][]string // 每个位置的 topic，同一个位置内的多个值是“或”的关系，空数组代表任意值
+}
+
+// MarshalJSON 转换为节点 eth_getLogs 所需要的参数格式
+func (f LogFilter) MarshalJSON() ([]byte, error) {
+	arg := map[string]interface{}{}
+	if f.BlockHash != "" {
+		if f.FromBlock != nil || f.ToBlock != nil {
+			return nil, fmt.Errorf("blockHash 不能和 fromBlock、toBlock 同时使用")
+		}
+		arg["blockHash"] = f.BlockHash
+	} else {
+		if f.FromBlock != nil {
+			arg["fromBlock"] = fmt.Sprintf("%#x", f.FromBlock)
+		}
+		if f.ToBlock != nil {
+			arg["toBlock"] = fmt.Sprintf("%#x", f.ToBlock)
+		}
+	}
+	if len(f.Addresses) > 0 {
+		arg["address"] = f.Addresses
+	}
+	if len(f.Topics) > 0 {
+		topics := make([]interface{}, len(f.Topics))
+		for i, slot := range f.Topics {
+			if len(slot) == 0 {
+				topics[i] = nil // null 代表这个位置匹配任意值
+				continue
+			}
+			topics[i] = slot
+		}
+		arg["topics"] = topics
+	}
+	return json.Marshal(arg)
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"eth-relay/dao"
+)
+
+// Receipt 交易收据结构体
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`   // 交易的哈希值
+	TransactionIndex  string `json:"transactionIndex"`  // 交易在区块中的下标
+	BlockHash         string `json:"blockHash"`         // 交易被打包的区块的哈希值
+	BlockNumber       string `json:"blockNumber"`       // 交易被打包的区块的区块号
+	From              string `json:"from"`              // 交易发起者的地址
+	To                string `json:"to"`                // 交易接收者的地址，创建合约时为空
+	Type              string `json:"type"`              // 交易类型，0x0 是传统交易，0x2 是 EIP-1559 交易
+	Status            string `json:"status"`            // 0x1 代表执行成功，0x0 代表执行失败
+	GasUsed           string `json:"gasUsed"`           // 这笔交易实际消耗的燃料
+	CumulativeGasUsed string `json:"cumulativeGasUsed"` // 区块中截止到这笔交易累计消耗的燃料
+	EffectiveGasPrice string `json:"effectiveGasPrice"` // 实际支付的燃料单价
+	ContractAddress   string `json:"contractAddress"`   // 创建合约时新合约的地址
+	LogsBloom         string `json:"logsBloom"`         // 日志的布隆过滤器
+	Logs              []Log  `json:"logs"`              // 交易产生的合约事件日志
+}
+
+// Succeeded 判断交易是否执行成功
+func (r *Receipt) Succeeded() bool {
+	return r.Status == "0x1"
+}
+
+// ToDao 转换为存储到数据库的收据结构体，日志以 json 格式保存
+func (r *Receipt) ToDao() dao.Receipt {
+	logs, _ := json.Marshal(r.Logs)
+	return dao.Receipt{
+		TransactionHash:   r.TransactionHash,
+		TransactionIndex:  r.TransactionIndex,
+		BlockHash:         r.BlockHash,
+		BlockNumber:       r.BlockNumber,
+		From:              r.From,
+		To:                r.To,
+		Type:              r.Type,
+		Status:            r.Status,
+		GasUsed:           r.GasUsed,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		EffectiveGasPrice: r.EffectiveGasPrice,
+		ContractAddress:   r.ContractAddress,
+		Logs:              string(logs),
+	}
+}
@@ -1,54 +1,112 @@
 package main
 
 import (
+	"fmt"
 	"math/big"
+	"strings"
 	"sync"
+	"time"
 )
 
 // nonce 管理器结构体
+// nonce 保存在 NonceStore 中，默认使用内存存储，
+// 多个中继实例共用一个钱包时使用 SQLNonceStore，重启后也不会重复或者跳过 nonce
 type NonceManager struct {
 	// lock 是互斥锁， go 的 map 类型不是协程安全的，
 	// 在读写 map 的时候，我们要考虑多协程并发的情况
 	lock sync.Mutex
 
-	// 采用整形大数来存储 nonce
-	nonceMemCache map[string]*big.Int
+	store             NonceStore           // nonce 存储
+	reconciled        map[string]time.Time // 地址最近一次和节点校正的时间
+	reconcileInterval time.Duration        // 和节点重新校正的间隔
 }
 
+// NewNonceManager 实例化使用内存存储的 nonce 管理器
 func NewNonceManager() *NonceManager {
+	return NewNonceManagerWithStore(NewMemNonceStore())
+}
+
+// NewNonceManagerWithStore 实例化使用 store 存储的 nonce 管理器
+func NewNonceManagerWithStore(store NonceStore) *NonceManager {
 	return &NonceManager{
-		lock: sync.Mutex{}, // 实例化互斥锁
+		lock:              sync.Mutex{}, // 实例化互斥锁
+		store:             store,
+		reconciled:        map[string]time.Time{},
+		reconcileInterval: time.Minute,
 	}
 }
 
+// SetReconcileInterval 设置和节点重新校正的间隔，校正时会清理已经上链的 nonce 记录和超时的预留
+func (n *NonceManager) SetReconcileInterval(interval time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.reconcileInterval = interval
+}
+
 // 设置 nonce
-func (n *NonceManager) SetNonce(address string, nonce *big.Int) {
-	if n.nonceMemCache == nil {
-		n.nonceMemCache = map[string]*big.Int{}
+func (n *NonceManager) SetNonce(address string, nonce *big.Int) error {
+	if err := n.store.Set(strings.ToLower(address), nonce.Uint64()); err != nil {
+		return fmt.Errorf("设置 nonce 失败 %w", err)
 	}
-	n.lock.Lock()         // 加锁
-	defer n.lock.Unlock() // 当该函数执行完毕，进行解锁
-	n.nonceMemCache[address] = nonce
+	return nil
 }
 
-// 根据以太坊地址获取 nonce
-func (n *NonceManager) GetNonce(address string) *big.Int {
-	if n.nonceMemCache == nil {
-		n.nonceMemCache = map[string]*big.Int{}
+// 根据以太坊地址获取 nonce，没有记录时返回 nil，存储出错时返回错误
+func (n *NonceManager) GetNonce(address string) (*big.Int, error) {
+	nonce, ok, err := n.store.Next(strings.ToLower(address))
+	if err != nil {
+		return nil, fmt.Errorf("获取 nonce 失败 %w", err)
 	}
-	n.lock.Lock()         // 加锁
-	defer n.lock.Unlock() // 当该函数执行完毕，进行解锁
-	return n.nonceMemCache[address]
+	if !ok {
+		return nil, nil
+	}
+	return new(big.Int).SetUint64(nonce), nil
 }
 
 // nonce 进行加 1 的操作
-func (n *NonceManager) PlusNonce(address string) {
-	if n.nonceMemCache == nil {
-		n.nonceMemCache = map[string]*big.Int{}
+func (n *NonceManager) PlusNonce(address string) error {
+	address = strings.ToLower(address)
+	nonce, generation, err := n.store.Reserve(address)
+	if err == nil {
+		err = n.store.Commit(address, nonce, generation)
 	}
+	if err != nil {
+		return fmt.Errorf("nonce 加 1 失败 %w", err)
+	}
+	return nil
+}
+
+// Reserve 为地址分配一个 nonce，分配出去的 nonce 需要使用返回的 generation 调用 Commit 或者 Release
+func (n *NonceManager) Reserve(address string) (nonce uint64, generation uint64, err error) {
+	return n.store.Reserve(strings.ToLower(address))
+}
+
+// Commit 确认 nonce 对应的交易已经广播成功
+func (n *NonceManager) Commit(address string, nonce, generation uint64) error {
+	return n.store.Commit(strings.ToLower(address), nonce, generation)
+}
+
+// Release 归还没有使用的 nonce
+func (n *NonceManager) Release(address string, nonce, generation uint64) error {
+	return n.store.Release(strings.ToLower(address), nonce, generation)
+}
+
+// Reconcile 使用节点上 pending 状态的 nonce 校正存储的 nonce
+func (n *NonceManager) Reconcile(address string, pending uint64) error {
+	address = strings.ToLower(address)
+	if err := n.store.Reconcile(address, pending); err != nil {
+		return err
+	}
+	n.lock.Lock()         // 加锁
+	defer n.lock.Unlock() // 当该函数执行完毕，进行解锁
+	n.reconciled[address] = time.Now()
+	return nil
+}
+
+// IsReconciled 判断地址在最近的 reconcileInterval 内是否已经校正过
+func (n *NonceManager) IsReconciled(address string) bool {
 	n.lock.Lock()         // 加锁
 	defer n.lock.Unlock() // 当该函数执行完毕，进行解锁
-	oldNonce := n.nonceMemCache[address]
-	newNonce := oldNonce.Add(oldNonce, big.NewInt(int64(1)))
-	n.nonceMemCache[address] = newNonce
+	last, ok := n.reconciled[strings.ToLower(address)]
+	return ok && time.Since(last) < n.reconcileInterval
 }
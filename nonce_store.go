@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNonceNotInitialized 代表存储中还没有这个地址的 nonce，需要先使用 Reconcile 初始化
+	ErrNonceNotInitialized = errors.New("nonce not initialized")
+	// ErrNonceNotReserved 代表 Commit 或者 Release 的 nonce 没有被预留，
+	// 或者预留已经超时并且被重新分配给了其它调用者
+	ErrNonceNotReserved = errors.New("nonce not reserved")
+)
+
+const (
+	nonceReserved  = "reserved"  // 已经分配给调用者，还没有广播
+	nonceCommitted = "committed" // 已经广播成功，等待被链上确认
+	nonceReleased  = "released"  // 签名或者广播失败后归还的 nonce，下一次分配时优先使用
+)
+
+// 预留或者广播超过这个时间还没有被节点确认的 nonce，在校正时视为已经丢失，
+// 超时的预留在 Reserve 时也会被重新分配
+const nonceReservationTimeout = 10 * time.Minute
+
+// NonceStore 是 nonce 的存储接口，每个函数都必须是原子的，
+// 多个中继实例使用同一个存储时不会分配出相同的 nonce
+type NonceStore interface {
+	// Next 返回地址下一个会被分配的 nonce，没有记录时 ok 为 false
+	Next(address string) (nonce uint64, ok bool, err error)
+	// Set 直接设置地址下一个会被分配的 nonce
+	Set(address string, nonce uint64) error
+	// Reserve 分配一个 nonce 并记录为已预留，优先分配归还的和预留超时的 nonce 中最小的那个，
+	// 没有记录时返回 ErrNonceNotInitialized
+	// generation 是这次预留的代数，同一个地址每次预留都不同，Commit 和 Release 时需要传入
+	Reserve(address string) (nonce uint64, generation uint64, err error)
+	// Commit 将已预留的 nonce 标记为广播成功，generation 和当前的预留不一致时返回 ErrNonceNotReserved
+	Commit(address string, nonce, generation uint64) error
+	// Release 归还已预留但是没有使用的 nonce，它会成为一个空缺，由下一次 Reserve 填补，
+	// generation 和当前的预留不一致时返回 ErrNonceNotReserved，超时后被重新分配的 nonce 不会被原来的调用者归还
+	Release(address string, nonce, generation uint64) error
+	// Reconcile 使用节点上 eth_getTransactionCount "pending" 的值 pending 校正存储的 nonce：
+	// 小于 pending 的已经上链或进入交易池，超时的预留视为丢失，
+	// 下一个 nonce 取 pending 和仍然有效的预留中较大的那个，
+	// 两者之间没有有效预留的 nonce 都作为空缺
+	Reconcile(address string, pending uint64) error
+}
+
+// 单个地址在内存中的 nonce 记录
+type memNonceAccount struct {
+	next         uint64
+	generation   uint64 // 最后一次预留的代数
+	reservations map[uint64]*memNonceReservation
+}
+
+type memNonceReservation struct {
+	status     string
+	generation uint64
+	updateTime time.Time
+}
+
+// MemNonceStore 是保存在内存中的 nonce 存储，重启后丢失，只适合单个实例使用
+type MemNonceStore struct {
+	lock     sync.Mutex
+	accounts map[string]*memNonceAccount
+}
+
+// NewMemNonceStore 实例化内存 nonce 存储
+func NewMemNonceStore() *MemNonceStore {
+	return &MemNonceStore{accounts: map[string]*memNonceAccount{}}
+}
+
+func (s *MemNonceStore) Next(address string) (uint64, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account, ok := s.accounts[address]
+	if !ok {
+		return 0, false, nil
+	}
+	return account.next, true, nil
+}
+
+func (s *MemNonceStore) Set(address string, nonce uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account := s.account(address)
+	account.next = nonce
+	// 被跳过的预留已经没有意义，删除后才能重新分配
+	for reserved := range account.reservations {
+		if reserved >= nonce {
+			delete(account.reservations, reserved)
+		}
+	}
+	return nil
+}
+
+func (s *MemNonceStore) Reserve(address string) (uint64, uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account, ok := s.accounts[address]
+	if !ok {
+		return 0, 0, ErrNonceNotInitialized
+	}
+	// 先填补空缺，空缺不填补的话之后的交易都不会被打包
+	// 超时还没有 Commit 或者 Release 的预留，例如调用者的实例崩溃了，也作为空缺重新分配
+	nonce, found := uint64(0), false
+	for gap, reservation := range account.reservations {
+		expired := reservation.status == nonceReserved && time.Since(reservation.updateTime) > nonceReservationTimeout
+		if (reservation.status == nonceReleased || expired) && (!found || gap < nonce) {
+			nonce, found = gap, true
+		}
+	}
+	if !found {
+		nonce = account.next
+		account.next++
+	}
+	account.generation++
+	account.reservations[nonce] = &memNonceReservation{status: nonceReserved, generation: account.generation, updateTime: time.Now()}
+	return nonce, account.generation, nil
+}
+
+func (s *MemNonceStore) Commit(address string, nonce, generation uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reservation := s.reservation(address, nonce)
+	if reservation == nil || reservation.status != nonceReserved || reservation.generation != generation {
+		return ErrNonceNotReserved
+	}
+	reservation.status = nonceCommitted
+	reservation.updateTime = time.Now()
+	return nil
+}
+
+func (s *MemNonceStore) Release(address string, nonce, generation uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reservation := s.reservation(address, nonce)
+	if reservation == nil || reservation.status != nonceReserved || reservation.generation != generation {
+		return ErrNonceNotReserved
+	}
+	account := s.accounts[address]
+	reservation.status = nonceReleased
+	reservation.updateTime = time.Now()
+	// 归还的是最后分配的 nonce 时直接回退，连在一起的空缺一起回退
+	for account.next > 0 {
+		last := account.reservations[account.next-1]
+		if last == nil || last.status != nonceReleased {
+			break
+		}
+		delete(account.reservations, account.next-1)
+		account.next--
+	}
+	return nil
+}
+
+func (s *MemNonceStore) Reconcile(address string, pending uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account := s.account(address)
+	next := pending
+	for nonce, reservation := range account.reservations {
+		if nonce < pending || reservation.status == nonceReleased ||
+			time.Since(reservation.updateTime) > nonceReservationTimeout {
+			delete(account.reservations, nonce)
+			continue
+		}
+		if nonce+1 > next {
+			next = nonce + 1
+		}
+	}
+	account.next = next
+	// pending 和有效预留之间的 nonce 都是空缺
+	for nonce := pending; nonce < next; nonce++ {
+		if _, ok := account.reservations[nonce]; !ok {
+			account.reservations[nonce] = &memNonceReservation{status: nonceReleased, updateTime: time.Now()}
+		}
+	}
+	return nil
+}
+
+// 获取地址的记录，不存在时新建，调用者需要持有锁
+func (s *MemNonceStore) account(address string) *memNonceAccount {
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &memNonceAccount{reservations: map[uint64]*memNonceReservation{}}
+		s.accounts[address] = account
+	}
+	return account
+}
+
+// 获取已经分配出去的 nonce 记录，调用者需要持有锁
+func (s *MemNonceStore) reservation(address string, nonce uint64) *memNonceReservation {
+	account, ok := s.accounts[address]
+	if !ok {
+		return nil
+	}
+	return account.reservations[nonce]
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"eth-relay/dao"
+	"fmt"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// SQLNonceStore 是保存在数据库中的 nonce 存储，需要同步 dao.Nonce 和 dao.NonceReservation 两张表
+// 每个操作都在数据库事务中使用 select ... for update 锁住地址的记录，
+// 多个中继实例共用同一个数据库时不会分配出相同的 nonce
+type SQLNonceStore struct {
+	mysql dao.MySQLConnector
+}
+
+// NewSQLNonceStore 实例化数据库 nonce 存储
+func NewSQLNonceStore(mysql dao.MySQLConnector) *SQLNonceStore {
+	return &SQLNonceStore{mysql: mysql}
+}
+
+func (s *SQLNonceStore) Next(address string) (uint64, bool, error) {
+	account := dao.Nonce{}
+	has, err := s.mysql.Db.Where("address = ?", address).Get(&account)
+	if err != nil || !has {
+		return 0, false, err
+	}
+	return account.NextNonce, true, nil
+}
+
+func (s *SQLNonceStore) Set(address string, nonce uint64) error {
+	return s.transaction(address, true, func(session *xorm.Session, account *dao.Nonce) error {
+		// 被跳过的预留已经没有意义，删除后才能重新分配
+		_, err := session.Where("address = ? and nonce >= ?", address, nonce).Delete(&dao.NonceReservation{})
+		account.NextNonce = nonce
+		return err
+	})
+}
+
+func (s *SQLNonceStore) Reserve(address string) (uint64, uint64, error) {
+	nonce, generation := uint64(0), uint64(0)
+	err := s.transaction(address, false, func(session *xorm.Session, account *dao.Nonce) error {
+		// 地址的记录在事务中被锁住，代数不会重复
+		account.Generation++
+		generation = account.Generation
+		// 先填补空缺，空缺不填补的话之后的交易都不会被打包
+		// 超时还没有 Commit 或者 Release 的预留，例如调用者的实例崩溃了，也作为空缺重新分配
+		expired := time.Now().Add(-nonceReservationTimeout).Unix()
+		gap := dao.NonceReservation{}
+		has, err := session.
+			Where("address = ? and (status = ? or (status = ? and update_time < ?))", address, nonceReleased, nonceReserved, expired).
+			Asc("nonce").Get(&gap)
+		if err != nil {
+			return err
+		}
+		if has {
+			nonce = gap.Nonce
+			_, err = session.ID(gap.Id).Cols("status", "generation", "update_time").
+				Update(&dao.NonceReservation{Status: nonceReserved, Generation: generation, UpdateTime: time.Now().Unix()})
+			return err
+		}
+		nonce = account.NextNonce
+		account.NextNonce++
+		_, err = session.Insert(&dao.NonceReservation{
+			Address:    address,
+			Nonce:      nonce,
+			Status:     nonceReserved,
+			Generation: generation,
+			UpdateTime: time.Now().Unix(),
+		})
+		return err
+	})
+	return nonce, generation, err
+}
+
+func (s *SQLNonceStore) Commit(address string, nonce, generation uint64) error {
+	affected, err := s.mysql.Db.
+		Where("address = ? and nonce = ? and status = ? and generation = ?", address, nonce, nonceReserved, generation).
+		Cols("status", "update_time").
+		Update(&dao.NonceReservation{Status: nonceCommitted, UpdateTime: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNonceNotReserved
+	}
+	return nil
+}
+
+func (s *SQLNonceStore) Release(address string, nonce, generation uint64) error {
+	return s.transaction(address, false, func(session *xorm.Session, account *dao.Nonce) error {
+		affected, err := session.
+			Where("address = ? and nonce = ? and status = ? and generation = ?", address, nonce, nonceReserved, generation).
+			Cols("status", "update_time").
+			Update(&dao.NonceReservation{Status: nonceReleased, UpdateTime: time.Now().Unix()})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNonceNotReserved
+		}
+		// 归还的是最后分配的 nonce 时直接回退，连在一起的空缺一起回退
+		for account.NextNonce > 0 {
+			last := dao.NonceReservation{}
+			has, err := session.Where("address = ? and nonce = ?", address, account.NextNonce-1).Get(&last)
+			if err != nil {
+				return err
+			}
+			if !has || last.Status != nonceReleased {
+				break
+			}
+			if _, err := session.ID(last.Id).Delete(&dao.NonceReservation{}); err != nil {
+				return err
+			}
+			account.NextNonce--
+		}
+		return nil
+	})
+}
+
+func (s *SQLNonceStore) Reconcile(address string, pending uint64) error {
+	return s.transaction(address, true, func(session *xorm.Session, account *dao.Nonce) error {
+		expired := time.Now().Add(-nonceReservationTimeout).Unix()
+		_, err := session.
+			Where("address = ? and (nonce < ? or status = ? or update_time < ?)", address, pending, nonceReleased, expired).
+			Delete(&dao.NonceReservation{})
+		if err != nil {
+			return err
+		}
+		// 仍然有效的预留
+		reservations := []dao.NonceReservation{}
+		if err := session.Where("address = ?", address).Find(&reservations); err != nil {
+			return err
+		}
+		active := map[uint64]bool{}
+		account.NextNonce = pending
+		for _, reservation := range reservations {
+			active[reservation.Nonce] = true
+			if reservation.Nonce+1 > account.NextNonce {
+				account.NextNonce = reservation.Nonce + 1
+			}
+		}
+		// pending 和有效预留之间的 nonce 都是空缺
+		gaps := []dao.NonceReservation{}
+		for nonce := pending; nonce < account.NextNonce; nonce++ {
+			if !active[nonce] {
+				gaps = append(gaps, dao.NonceReservation{
+					Address:    address,
+					Nonce:      nonce,
+					Status:     nonceReleased,
+					UpdateTime: time.Now().Unix(),
+				})
+			}
+		}
+		if len(gaps) > 0 {
+			_, err = session.Insert(&gaps)
+		}
+		return err
+	})
+}
+
+// 在数据库事务中锁住地址的 nonce 记录后执行 fn，fn 返回错误时回滚
+// create 为 true 时地址没有记录会新建一条，否则返回 ErrNonceNotInitialized
+func (s *SQLNonceStore) transaction(address string, create bool, fn func(session *xorm.Session, account *dao.Nonce) error) error {
+	err := s.lockedTransaction(address, create, fn)
+	if errors.Is(err, errNonceConflict) {
+		// 其它实例同时新建了这个地址的记录，重新执行一次就能锁住它
+		err = s.lockedTransaction(address, create, fn)
+	}
+	return err
+}
+
+// 新建地址记录时和其它实例冲突
+var errNonceConflict = errors.New("nonce record conflict")
+
+func (s *SQLNonceStore) lockedTransaction(address string, create bool, fn func(session *xorm.Session, account *dao.Nonce) error) error {
+	session := s.mysql.Db.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	account := dao.Nonce{}
+	has, err := session.Where("address = ?", address).ForUpdate().Get(&account)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+	if !has {
+		if !create {
+			session.Rollback()
+			return ErrNonceNotInitialized
+		}
+		account = dao.Nonce{Address: address, UpdateTime: time.Now().Unix()}
+		if _, err := session.Insert(&account); err != nil {
+			session.Rollback()
+			return fmt.Errorf("%w: %s", errNonceConflict, err.Error())
+		}
+	}
+	if err := fn(session, &account); err != nil {
+		session.Rollback()
+		return err
+	}
+	account.UpdateTime = time.Now().Unix()
+	if _, err := session.ID(account.Id).Cols("next_nonce", "generation", "update_time").Update(&account); err != nil {
+		session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"eth-relay/dao"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// 按照 Reserve、Commit、Release、Reconcile 的顺序检查 nonce 存储
+func testNonceStore(t *testing.T, store NonceStore) {
+	address := "0x1111111111111111111111111111111111111111"
+	if _, _, err := store.Reserve(address); !errors.Is(err, ErrNonceNotInitialized) {
+		t.Fatalf("没有初始化时应该返回 ErrNonceNotInitialized %v", err)
+	}
+	if err := store.Reconcile(address, 5); err != nil {
+		t.Fatal(err)
+	}
+	first, firstGen, _ := store.Reserve(address)
+	second, secondGen, _ := store.Reserve(address)
+	if first != 5 || second != 6 || firstGen == secondGen {
+		t.Fatalf("分配的 nonce 错误 %d %d", first, second)
+	}
+	if err := store.Commit(address, first, secondGen); !errors.Is(err, ErrNonceNotReserved) {
+		t.Fatalf("代数不一致时不能确认 %v", err)
+	}
+	if err := store.Commit(address, first, firstGen); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(address, first, firstGen); !errors.Is(err, ErrNonceNotReserved) {
+		t.Fatalf("已经广播的 nonce 不能归还 %v", err)
+	}
+	// 归还最后分配的 nonce 后回退
+	if err := store.Release(address, second, secondGen); err != nil {
+		t.Fatal(err)
+	}
+	if next, _, _ := store.Next(address); next != 6 {
+		t.Fatalf("归还后下一个 nonce 错误 %d", next)
+	}
+	// 归还中间的 nonce 成为空缺，下一次优先分配
+	gap, gapGen, _ := store.Reserve(address)
+	third, thirdGen, _ := store.Reserve(address)
+	if err := store.Release(address, gap, gapGen); err != nil {
+		t.Fatal(err)
+	}
+	nonce, refillGen, _ := store.Reserve(address)
+	if nonce != gap {
+		t.Fatalf("没有填补空缺 %d", nonce)
+	}
+	store.Commit(address, gap, refillGen)
+	store.Commit(address, third, thirdGen)
+	// 节点的 pending 落后于已经广播的交易时，不能重复分配
+	if err := store.Reconcile(address, 5); err != nil {
+		t.Fatal(err)
+	}
+	if next, _, _ := store.Next(address); next != 8 {
+		t.Fatalf("校正后下一个 nonce 错误 %d", next)
+	}
+	// 其它程序使用了这个钱包，以节点为准
+	if err := store.Reconcile(address, 9); err != nil {
+		t.Fatal(err)
+	}
+	if next, _, _ := store.Next(address); next != 9 {
+		t.Fatalf("校正后下一个 nonce 错误 %d", next)
+	}
+}
+
+// 预留之后一直没有 Commit 或者 Release 的 nonce，超时后重新分配，age 把预留的时间改到超时之前
+func testExpiredReservation(t *testing.T, store NonceStore, age func(address string, nonce uint64)) {
+	address := "0x4444444444444444444444444444444444444444"
+	if err := store.Reconcile(address, 0); err != nil {
+		t.Fatal(err)
+	}
+	lost, lostGen, _ := store.Reserve(address)
+	next, nextGen, _ := store.Reserve(address)
+	if err := store.Commit(address, next, nextGen); err != nil {
+		t.Fatal(err)
+	}
+	if nonce, _, _ := store.Reserve(address); nonce != 2 {
+		t.Fatalf("没有超时的预留不能重新分配 %d", nonce)
+	}
+	age(address, lost)
+	nonce, generation, _ := store.Reserve(address)
+	if nonce != lost {
+		t.Fatalf("超时的预留没有重新分配 %d", nonce)
+	}
+	// 原来的调用者迟到的 Release 和 Commit 不能影响新的预留
+	if err := store.Release(address, lost, lostGen); !errors.Is(err, ErrNonceNotReserved) {
+		t.Fatalf("超时的预留不能被原来的调用者归还 %v", err)
+	}
+	if err := store.Commit(address, lost, lostGen); !errors.Is(err, ErrNonceNotReserved) {
+		t.Fatalf("超时的预留不能被原来的调用者确认 %v", err)
+	}
+	if err := store.Commit(address, lost, generation); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 单元测试：内存 nonce 存储
+func Test_MemNonceStore(t *testing.T) {
+	testNonceStore(t, NewMemNonceStore())
+	store := NewMemNonceStore()
+	testExpiredReservation(t, store, func(address string, nonce uint64) {
+		store.reservation(address, nonce).updateTime = time.Now().Add(-nonceReservationTimeout - time.Second)
+	})
+}
+
+// 单元测试：数据库 nonce 存储，需要本地的数据库
+func Test_SQLNonceStore(t *testing.T) {
+	option := dao.MySQLOptions{
+		HostName:           "127.0.0.1",
+		Port:               "3306",
+		DbName:             "eth_reply",
+		User:               "root",
+		Password:           "",
+		TablePrefix:        "eth_",
+		MaxOpenConnections: 10,
+		MaxIdleConnections: 5,
+		ConnMaxLifetime:    15,
+	}
+	mysql, err := dao.NewMySQLConnector(&option, []interface{}{dao.Nonce{}, dao.NonceReservation{}})
+	if err != nil {
+		fmt.Println("数据库初始化失败", err.Error())
+		return
+	}
+	mysql.Db.Where("address = ?", "0x1111111111111111111111111111111111111111").Delete(&dao.Nonce{})
+	mysql.Db.Where("address = ?", "0x1111111111111111111111111111111111111111").Delete(&dao.NonceReservation{})
+	testNonceStore(t, NewSQLNonceStore(mysql))
+	mysql.Db.Where("address = ?", "0x4444444444444444444444444444444444444444").Delete(&dao.Nonce{})
+	mysql.Db.Where("address = ?", "0x4444444444444444444444444444444444444444").Delete(&dao.NonceReservation{})
+	testExpiredReservation(t, NewSQLNonceStore(mysql), func(address string, nonce uint64) {
+		mysql.Db.Where("address = ? and nonce = ?", address, nonce).Cols("update_time").
+			Update(&dao.NonceReservation{UpdateTime: time.Now().Add(-nonceReservationTimeout - time.Second).Unix()})
+	})
+}
+
+// 单元测试：并发分配的 nonce 不会重复
+func Test_NonceManagerReserve(t *testing.T) {
+	manager := NewNonceManager()
+	address := "0xABCDEF0000000000000000000000000000000000"
+	if err := manager.Reconcile(address, 0); err != nil {
+		t.Fatal(err)
+	}
+	lock := sync.Mutex{}
+	seen := map[uint64]bool{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, _, err := manager.Reserve(address)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if seen[nonce] {
+				t.Errorf("重复分配 nonce %d", nonce)
+			}
+			seen[nonce] = true
+		}()
+	}
+	wg.Wait()
+	// 地址不区分大小写
+	if nonce, err := manager.GetNonce("0xabcdef0000000000000000000000000000000000"); err != nil || nonce == nil || nonce.Uint64() != 50 {
+		t.Fatalf("下一个 nonce 错误 %v %v", nonce, err)
+	}
+}
+
+// 单元测试：第一次使用地址时和节点的 pending nonce 校正
+func Test_ReserveNonce(t *testing.T) {
+	address := "0x2222222222222222222222222222222222222222"
+	service := &testEthService{number: 10, nonces: map[string]uint64{address: 7}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	nonce, _, err := requester.reserveNonce(context.Background(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != 7 || !requester.nonceManager.IsReconciled(address) {
+		t.Fatalf("校正后的 nonce 错误 %d", nonce)
+	}
+	// 校正过后不再请求节点
+	service.nonces[address] = 100
+	if nonce, _, _ := requester.reserveNonce(context.Background(), address); nonce != 8 {
+		t.Fatalf("nonce 错误 %d", nonce)
+	}
+}
+
+// 单元测试：广播失败时归还 nonce，下一笔交易填补空缺
+func Test_SendWithNonce(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{address: 3}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	to := "0x3333333333333333333333333333333333333333"
+
+	// nonce 3 广播失败，同时另一笔交易预留了 nonce 4
+	service.sendErrs = []string{"insufficient funds for gas * price + value"}
+	other := uint64(0)
+	_, err := requester.sendWithNonce(context.Background(), address, func(nonce uint64) *types.Transaction {
+		other, _, _ = requester.nonceManager.Reserve(address)
+		return types.NewTransaction(nonce, common.HexToAddress(to), big.NewInt(1), 21000, big.NewInt(1), nil)
+	})
+	if err == nil || other != 4 {
+		t.Fatalf("广播应该失败 %v %d", err, other)
+	}
+	// 空缺 3 被下一笔交易填补
+	if _, err := requester.SendETHTransaction(address, to, "0.1", 21000, 1); err != nil {
+		t.Fatal(err)
+	}
+	// nonce 已经被使用时不归还
+	service.sendErrs = []string{"nonce too low"}
+	if _, err := requester.SendETHTransaction(address, to, "0.1", 21000, 1); !errors.Is(err, ErrNonceUsed) {
+		t.Fatalf("应该返回 ErrNonceUsed %v", err)
+	}
+	if _, err := requester.SendETHTransaction(address, to, "0.1", 21000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(service.sent) != "[3 6]" {
+		t.Fatalf("广播的 nonce 错误 %v", service.sent)
+	}
+}
+
+// 单元测试：广播超时时节点可能已经收到交易，nonce 不归还，同时返回交易哈希值
+func Test_SendWithNonceUncertain(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{address: 3}, delays: []time.Duration{300 * time.Millisecond}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	to := "0x3333333333333333333333333333333333333333"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	txHash, err := requester.sendWithNonce(ctx, address, func(nonce uint64) *types.Transaction {
+		return types.NewTransaction(nonce, common.HexToAddress(to), big.NewInt(1), 21000, big.NewInt(1), nil)
+	})
+	if !errors.Is(err, ErrSendUncertain) || txHash == "" {
+		t.Fatalf("应该同时返回交易哈希值和 ErrSendUncertain %s %v", txHash, err)
+	}
+	// 节点在超时之后收下了交易，nonce 3 不能再分配给下一笔交易
+	time.Sleep(300 * time.Millisecond)
+	if _, err := requester.SendETHTransaction(address, to, "0.1", 21000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(service.sent) != "[3 4]" {
+		t.Fatalf("广播的 nonce 错误 %v", service.sent)
+	}
+}
+
+// 单元测试：定时重新校正，已经上链的 nonce 记录被清理
+func Test_ReserveNonceReconcileAgain(t *testing.T) {
+	address := "0x2222222222222222222222222222222222222222"
+	service := &testEthService{number: 10, nonces: map[string]uint64{address: 0}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	store := NewMemNonceStore()
+	manager := NewNonceManagerWithStore(store)
+	requester.SetNonceManager(manager)
+	for i := 0; i < 3; i++ {
+		nonce, generation, err := requester.reserveNonce(context.Background(), address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager.Commit(address, nonce, generation)
+	}
+	if len(store.accounts[address].reservations) != 3 {
+		t.Fatalf("预留记录错误 %d", len(store.accounts[address].reservations))
+	}
+	// 三笔交易都已经上链，超过校正间隔后重新校正
+	service.nonces[address] = 3
+	manager.SetReconcileInterval(0)
+	nonce, _, err := requester.reserveNonce(context.Background(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != 3 || len(store.accounts[address].reservations) != 1 {
+		t.Fatalf("重新校正错误 %d %d", nonce, len(store.accounts[address].reservations))
+	}
+}
+
+// 存储出错的 nonce 存储
+type failingNonceStore struct {
+	*MemNonceStore
+}
+
+func (s *failingNonceStore) Next(address string) (uint64, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+// 单元测试：存储出错时返回错误，而不是返回 nil 让调用者继续使用
+func Test_NonceManagerStoreError(t *testing.T) {
+	manager := NewNonceManagerWithStore(&failingNonceStore{MemNonceStore: NewMemNonceStore()})
+	if nonce, err := manager.GetNonce("0x1111111111111111111111111111111111111111"); err == nil || nonce != nil {
+		t.Fatalf("应该返回错误 %v %v", nonce, err)
+	}
+	if err := manager.PlusNonce("0x1111111111111111111111111111111111111111"); !errors.Is(err, ErrNonceNotInitialized) {
+		t.Fatalf("应该返回 ErrNonceNotInitialized %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"eth-relay/dao"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DecimalAuto 作为 decimal 参数时，代币的 decimal 由代币信息注册表从合约中获取
+const DecimalAuto = -1
+
+// ErrTokenDecimals 代表无法从合约中获取代币的 decimal，通常是地址上不是 ERC20 合约
+var ErrTokenDecimals = errors.New("token decimals unavailable")
+
+// ERC20 代币信息函数的 methodId
+const (
+	erc20NameMethod        = "0x06fdde03" // name()
+	erc20SymbolMethod      = "0x95d89b41" // symbol()
+	erc20DecimalsMethod    = "0x313ce567" // decimals()
+	erc20TotalSupplyMethod = "0x18160ddd" // totalSupply()
+)
+
+// 按照 abi 的 string 类型解析 name 和 symbol 的返回值
+var tokenStringArgs = func() abi.Arguments {
+	stringType, _ := abi.NewType("string", "", nil)
+	return abi.Arguments{{Type: stringType}}
+}()
+
+// TokenRegistry 代币信息注册表
+// 代币信息从合约获取后缓存在内存中，设置了数据库时同时保存到 dao.Token 表，重启后不再请求节点
+type TokenRegistry struct {
+	requester *ETHRPCRequester
+	mysql     *dao.MySQLConnector // 为空时只缓存在内存中
+
+	lock   sync.RWMutex
+	tokens map[string]*dao.Token // key 为小写的合约地址
+}
+
+// NewTokenRegistry 实例化只缓存在内存中的代币信息注册表
+func NewTokenRegistry(requester *ETHRPCRequester) *TokenRegistry {
+	return &TokenRegistry{requester: requester, tokens: map[string]*dao.Token{}}
+}
+
+// NewTokenRegistryWithDB 实例化同时保存到数据库的代币信息注册表，需要同步 dao.Token 表
+func NewTokenRegistryWithDB(requester *ETHRPCRequester, mysql dao.MySQLConnector) *TokenRegistry {
+	registry := NewTokenRegistry(requester)
+	registry.mysql = &mysql
+	return registry
+}
+
+// Token 获取代币信息，依次查询内存、数据库和合约
+func (t *TokenRegistry) Token(contract string) (*dao.Token, error) {
+	ctx, cancel := t.requester.defaultContext()
+	defer cancel()
+	return t.TokenContext(ctx, contract)
+}
+
+// TokenContext 获取代币信息，依次查询内存、数据库和合约
+func (t *TokenRegistry) TokenContext(ctx context.Context, contract string) (*dao.Token, error) {
+	if !common.IsHexAddress(contract) {
+		return nil, errors.New("invalid contract address")
+	}
+	contract = strings.ToLower(contract)
+	t.lock.RLock()
+	token, ok := t.tokens[contract]
+	t.lock.RUnlock()
+	if ok {
+		copied := *token
+		return &copied, nil
+	}
+	if t.mysql != nil {
+		token := dao.Token{}
+		has, err := t.mysql.Db.Where("contract_address = ?", contract).Get(&token)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			t.cache(&token)
+			return &token, nil
+		}
+	}
+	return t.RefreshContext(ctx, contract)
+}
+
+// Refresh 重新从合约获取代币信息并更新缓存，主要用于更新 totalSupply
+func (t *TokenRegistry) Refresh(contract string) (*dao.Token, error) {
+	ctx, cancel := t.requester.defaultContext()
+	defer cancel()
+	return t.RefreshContext(ctx, contract)
+}
+
+// RefreshContext 重新从合约获取代币信息并更新缓存，主要用于更新 totalSupply
+func (t *TokenRegistry) RefreshContext(ctx context.Context, contract string) (*dao.Token, error) {
+	if !common.IsHexAddress(contract) {
+		return nil, errors.New("invalid contract address")
+	}
+	token, err := t.fetch(ctx, strings.ToLower(contract))
+	if err != nil {
+		return nil, err
+	}
+	if t.mysql != nil {
+		if err := t.save(token); err != nil {
+			return nil, err
+		}
+	}
+	t.cache(token)
+	copied := *token
+	return &copied, nil
+}
+
+// Decimals 获取代币的 decimal
+func (t *TokenRegistry) Decimals(contract string) (int, error) {
+	ctx, cancel := t.requester.defaultContext()
+	defer cancel()
+	return t.DecimalsContext(ctx, contract)
+}
+
+// DecimalsContext 获取代币的 decimal
+func (t *TokenRegistry) DecimalsContext(ctx context.Context, contract string) (int, error) {
+	token, err := t.TokenContext(ctx, contract)
+	if err != nil {
+		return 0, err
+	}
+	return token.Decimals, nil
+}
+
+func (t *TokenRegistry) cache(token *dao.Token) {
+	copied := *token
+	t.lock.Lock()
+	t.tokens[token.ContractAddress] = &copied
+	t.lock.Unlock()
+}
+
+// 保存到数据库，已经存在时更新
+func (t *TokenRegistry) save(token *dao.Token) error {
+	existing := dao.Token{}
+	has, err := t.mysql.Db.Where("contract_address = ?", token.ContractAddress).Get(&existing)
+	if err != nil {
+		return err
+	}
+	if !has {
+		_, err = t.mysql.Db.Insert(token)
+		return err
+	}
+	token.Id = existing.Id
+	_, err = t.mysql.Db.ID(existing.Id).AllCols().Update(token)
+	return err
+}
+
+// 批量调用合约的四个代币信息函数
+// name 和 symbol 在 ERC20 标准中是可选的，获取失败时为空；decimals 获取失败时返回 ErrTokenDecimals
+func (t *TokenRegistry) fetch(ctx context.Context, contract string) (*dao.Token, error) {
+	methods := []string{erc20NameMethod, erc20SymbolMethod, erc20DecimalsMethod, erc20TotalSupplyMethod}
+	results := make([]hexutil.Bytes, len(methods))
+	reqs := []rpc.BatchElem{}
+	for i, method := range methods {
+		arg := map[string]interface{}{"to": contract, "data": method}
+		reqs = append(reqs, rpc.BatchElem{
+			Method: "eth_call",
+			Args:   []interface{}{arg, LatestBlock},
+			Result: &results[i],
+		})
+	}
+	if err := t.requester.client.BatchCallContext(ctx, reqs); err != nil {
+		return nil, fmt.Errorf("获取代币信息失败 %s", err.Error())
+	}
+	token := &dao.Token{ContractAddress: contract, UpdateTime: time.Now().Unix()}
+	if reqs[0].Error == nil {
+		token.Name = decodeTokenString(results[0])
+	}
+	if reqs[1].Error == nil {
+		token.Symbol = decodeTokenString(results[1])
+	}
+	decimals := new(big.Int).SetBytes(results[2])
+	if reqs[2].Error != nil || len(results[2]) == 0 || decimals.Cmp(big.NewInt(255)) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTokenDecimals, contract)
+	}
+	token.Decimals = int(decimals.Int64())
+	if reqs[3].Error == nil {
+		token.TotalSupply = new(big.Int).SetBytes(results[3]).String()
+	}
+	return token, nil
+}
+
+// 解析 name 和 symbol 的返回值，一些早期的代币（例如 MKR）返回的是 bytes32
+func decodeTokenString(data []byte) string {
+	if len(data) == 32 {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	values, err := tokenStringArgs.Unpack(data)
+	if err != nil || len(values) == 0 {
+		return ""
+	}
+	value, _ := values[0].(string)
+	return value
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// 按照 abi 的 string 类型编码
+func encodeTokenString(value string) hexutil.Bytes {
+	data, _ := tokenStringArgs.Pack(value)
+	return data
+}
+
+// 单元测试：从合约获取代币信息并缓存，decimal 为 DecimalAuto 时转账和查询余额使用注册表中的 decimal
+func Test_TokenRegistry(t *testing.T) {
+	address := unlockTestAccount(t)
+	symbol := make([]byte, 32)
+	copy(symbol, "MKR")
+	service := &testEthService{number: 10, chainId: 1, nonces: map[string]uint64{}, gas: 50000, price: 7}
+	service.calls = map[string]hexutil.Bytes{
+		erc20NameMethod:        encodeTokenString("Maker"),
+		erc20SymbolMethod:      symbol, // 返回 bytes32 的早期代币
+		erc20DecimalsMethod:    common.LeftPadBytes([]byte{6}, 32),
+		erc20TotalSupplyMethod: common.LeftPadBytes(big.NewInt(1000000).Bytes(), 32),
+		"0x70a08231":           common.LeftPadBytes(big.NewInt(2500000).Bytes(), 32), // balanceOf
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	token := "0x4444444444444444444444444444444444444444"
+
+	info, err := requester.TokenRegistry().Token(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Maker" || info.Symbol != "MKR" || info.Decimals != 6 || info.TotalSupply != "1000000" || info.ContractAddress != token {
+		t.Fatalf("代币信息错误 %+v", info)
+	}
+
+	// 按照注册表中的 decimal 转账 1.5 个代币
+	if _, err := requester.SendERC20Transaction(address, token, address, "1.5", 0, 0, DecimalAuto); err != nil {
+		t.Fatal(err)
+	}
+	amount := new(big.Int).SetBytes(service.lastTx.Data()[36:])
+	if amount.Int64() != 1500000 {
+		t.Fatalf("转账数值错误 %v", amount)
+	}
+	// 小数位数超过代币精度
+	if _, err := requester.SendERC20Transaction(address, token, address, "1.0000001", 0, 0, DecimalAuto); err == nil {
+		t.Fatal("超过代币精度时应该返回错误")
+	}
+	params := []ERC20BalanceRpcReq{
+		{ContractAddress: token, UserAddress: address, ContractDecimal: DecimalAuto},
+		{ContractAddress: token, UserAddress: address, ContractDecimal: 2},
+	}
+	balances, err := requester.GetERC20BalancesFormatted(params, LatestBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances[0] != "2.5" || balances[1] != "25000" {
+		t.Fatalf("余额错误 %v", balances)
+	}
+
+	// 已经缓存的代币不再请求节点
+	service.calls = map[string]hexutil.Bytes{}
+	if decimals, err := requester.TokenRegistry().Decimals(token); err != nil || decimals != 6 {
+		t.Fatalf("应该使用缓存 %d %v", decimals, err)
+	}
+	// 没有 decimals 函数的合约
+	if _, err := requester.TokenRegistry().Token("0x5555555555555555555555555555555555555555"); !errors.Is(err, ErrTokenDecimals) {
+		t.Fatalf("应该返回 ErrTokenDecimals %v", err)
+	}
+}
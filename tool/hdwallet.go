@@ -0,0 +1,208 @@
+package tool
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// 以太坊 BIP-44 账户的派生路径 m/44'/60'/0'，第 i 个地址是 m/44'/60'/0'/0/i
+var HDAccountPath = accounts.DerivationPath{
+	0x80000000 + 44, 0x80000000 + 60, 0x80000000 + 0,
+}
+
+// 已经解锁的 HD 钱包派生出的私钥，key 为派生地址，和 ETHUnlockMap 一样用于 SignETHTransaction
+var hdUnlockMap = map[string]*ecdsa.PrivateKey{}
+
+// NewMnemonic 生成 BIP-39 助记词，bits 为熵的位数，128 对应 12 个单词，256 对应 24 个单词
+func NewMnemonic(bits int) (string, error) {
+	entropy, err := bip39.NewEntropy(bits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// HDWallet 是由 BIP-39 助记词生成的 BIP-32 分层确定性钱包
+type HDWallet struct {
+	account *hdkeychain.ExtendedKey // m/44'/60'/0' 的扩展私钥
+}
+
+// NewHDWallet 导入 BIP-39 助记词，passphrase 是可选的助记词密码，没有时传空字符串
+func NewHDWallet(mnemonic, passphrase string) (*HDWallet, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %s", err.Error())
+	}
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+	account, err := derivePath(master, HDAccountPath)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{account: account}, nil
+}
+
+// 从 key 开始按照 path 逐级派生
+func derivePath(key *hdkeychain.ExtendedKey, path accounts.DerivationPath) (*hdkeychain.ExtendedKey, error) {
+	var err error
+	for _, index := range path {
+		if key, err = key.Derive(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// PrivateKey 派生 m/44'/60'/0'/0/index 的私钥
+func (w *HDWallet) PrivateKey(index uint32) (*ecdsa.PrivateKey, error) {
+	key, err := derivePath(w.account, accounts.DerivationPath{0, index})
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return privateKey.ToECDSA(), nil
+}
+
+// Address 派生 m/44'/60'/0'/0/index 的地址
+func (w *HDWallet) Address(index uint32) (common.Address, error) {
+	privateKey, err := w.PrivateKey(index)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(privateKey.PublicKey), nil
+}
+
+// Signer 返回 m/44'/60'/0'/0/index 账户的签名者，不需要解锁到全局状态中
+func (w *HDWallet) Signer(index uint32) (*PrivateKeySigner, error) {
+	privateKey, err := w.PrivateKey(index)
+	if err != nil {
+		return nil, err
+	}
+	return NewPrivateKeySigner(privateKey), nil
+}
+
+// AccountXPub 返回 m/44'/60'/0' 的扩展公钥，只能派生地址，不能派生私钥，
+// 用于在不保存私钥的服务器上生成充值地址
+func (w *HDWallet) AccountXPub() (string, error) {
+	public, err := w.account.Neuter()
+	if err != nil {
+		return "", err
+	}
+	return public.String(), nil
+}
+
+// XPubWallet 是只有扩展公钥的观察钱包，只能派生地址
+type XPubWallet struct {
+	external *hdkeychain.ExtendedKey // 外部链 m/44'/60'/0'/0 的扩展公钥
+}
+
+// NewXPubWallet 导入 AccountXPub 返回的扩展公钥，传入扩展私钥时返回错误，避免私钥出现在扫描服务器上
+func NewXPubWallet(xpub string) (*XPubWallet, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpub: %s", err.Error())
+	}
+	if key.IsPrivate() {
+		return nil, errors.New("extended private key is not allowed, use the xpub")
+	}
+	external, err := key.Derive(0)
+	if err != nil {
+		return nil, err
+	}
+	return &XPubWallet{external: external}, nil
+}
+
+// Address 派生 m/44'/60'/0'/0/index 的地址，和 HDWallet.Address 的结果一致
+func (w *XPubWallet) Address(index uint32) (common.Address, error) {
+	key, err := w.external.Derive(index)
+	if err != nil {
+		return common.Address{}, err
+	}
+	publicKey, err := key.ECPubKey()
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*publicKey.ToECDSA()), nil
+}
+
+// UnlockHDAccount 解锁 HD 钱包中 m/44'/60'/0'/0/index 的账户，之后可以用返回的地址调用 SignETHTransaction
+func UnlockHDAccount(wallet *HDWallet, index uint32) (string, error) {
+	return TimedUnlockHDAccount(wallet, index, 0, "")
+}
+
+// TimedUnlockHDAccount 解锁 HD 钱包中 m/44'/60'/0'/0/index 的账户，timeout 之后自动锁定，timeout 为 0 时一直解锁
+func TimedUnlockHDAccount(wallet *HDWallet, index uint32, timeout time.Duration, caller string) (string, error) {
+	privateKey, err := wallet.PrivateKey(index)
+	if err != nil {
+		return "", err
+	}
+	account := crypto.PubkeyToAddress(privateKey.PublicKey)
+	unlockLock.Lock()
+	defer unlockLock.Unlock()
+	hdUnlockMap[account.String()] = privateKey
+	startUnlockSession(account, caller, timeout)
+	audit(AuditUnlock, account, caller, fmt.Sprintf("hd index %d, %s", index, unlockDetail(timeout)))
+	return account.String(), nil
+}
+
+// 使用 HD 钱包全局解锁状态签名的 Signer，自己不持有私钥，每次签名时从 hdUnlockMap 中获取，
+// 账户被 LockETHWallet 锁定或者解锁到期之后不能再签名
+type hdUnlockedSigner struct {
+	address common.Address
+}
+
+func (s *hdUnlockedSigner) Address() common.Address {
+	return s.address
+}
+
+// 获取账户当前解锁的私钥
+func (s *hdUnlockedSigner) signer() (*PrivateKeySigner, error) {
+	unlockLock.RLock()
+	defer unlockLock.RUnlock()
+	privateKey, ok := hdUnlockMap[s.address.String()]
+	if !ok {
+		return nil, errors.New("account need to unlock first")
+	}
+	return NewPrivateKeySigner(privateKey), nil
+}
+
+func (s *hdUnlockedSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignTx(tx, chainID)
+}
+
+func (s *hdUnlockedSigner) SignMessage(message []byte) ([]byte, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignMessage(message)
+}
+
+func (s *hdUnlockedSigner) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	signer, err := s.signer()
+	if err != nil {
+		return nil, err
+	}
+	return signer.SignTypedData(typedData)
+}
@@ -0,0 +1,155 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 管理 keystore 账户的审计事件，和解锁事件记录在同一个审计日志中
+const (
+	AuditImport         = "import"
+	AuditExport         = "export"
+	AuditChangePassword = "change_password"
+	AuditDelete         = "delete"
+)
+
+// ErrDeleteNotConfirmed 删除账户时确认的地址和要删除的地址不一致
+var ErrDeleteNotConfirmed = errors.New("delete not confirmed, confirm with the account address")
+
+// KeystoreAccount 是 keystore 文件夹中的一个账户
+type KeystoreAccount struct {
+	Address    common.Address
+	File       string    // keystore 文件的路径
+	CreateTime time.Time // 从 geth 生成的文件名 UTC--<时间>--<地址> 中解析，其它文件名使用修改时间
+}
+
+// KeystoreManager 管理 keystore 文件夹中的账户，代替手动往 keystores 文件夹中复制文件
+type KeystoreManager struct {
+	ks *keystore.KeyStore
+}
+
+// NewKeystoreManager 管理 keysDir 中的账户，scryptN 和 scryptP 是新加密的 keystore 使用的参数，
+// 生产环境使用 keystore.StandardScryptN 和 keystore.StandardScryptP
+func NewKeystoreManager(keysDir string, scryptN, scryptP int) *KeystoreManager {
+	return &KeystoreManager{ks: keystore.NewKeyStore(keysDir, scryptN, scryptP)}
+}
+
+// 按照地址查找账户，同一个地址有多个 keystore 文件时返回错误
+func (m *KeystoreManager) find(address string) (accounts.Account, error) {
+	if !common.IsHexAddress(address) {
+		return accounts.Account{}, errors.New("invalid address")
+	}
+	return m.ks.Find(accounts.Account{Address: common.HexToAddress(address)})
+}
+
+// ImportPrivateKey 导入十六进制的私钥，使用 password 加密保存为 keystore 文件
+func (m *KeystoreManager) ImportPrivateKey(hexKey, password, caller string) (common.Address, error) {
+	key, err := crypto.HexToECDSA(common.Bytes2Hex(common.FromHex(hexKey)))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid private key: %s", err.Error())
+	}
+	account, err := m.ks.ImportECDSA(key, password)
+	if err != nil {
+		return common.Address{}, err
+	}
+	audit(AuditImport, account.Address, caller, "private key")
+	return account.Address, nil
+}
+
+// ImportKeystore 导入其它地方导出的 keystore json，password 是原来的密码，newPassword 是保存时使用的新密码
+func (m *KeystoreManager) ImportKeystore(keyJSON []byte, password, newPassword, caller string) (common.Address, error) {
+	account, err := m.ks.Import(keyJSON, password, newPassword)
+	if err != nil {
+		return common.Address{}, err
+	}
+	audit(AuditImport, account.Address, caller, "keystore")
+	return account.Address, nil
+}
+
+// ExportKeystore 导出账户的 keystore json，使用 newPassword 重新加密，文件夹中的 keystore 不变
+func (m *KeystoreManager) ExportKeystore(address, password, newPassword, caller string) ([]byte, error) {
+	account, err := m.find(address)
+	if err != nil {
+		return nil, err
+	}
+	keyJSON, err := m.ks.Export(account, password, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	audit(AuditExport, account.Address, caller, "")
+	return keyJSON, nil
+}
+
+// ChangePassword 修改账户的 keystore 密码
+func (m *KeystoreManager) ChangePassword(address, password, newPassword, caller string) error {
+	account, err := m.find(address)
+	if err != nil {
+		return err
+	}
+	if err := m.ks.Update(account, password, newPassword); err != nil {
+		return err
+	}
+	audit(AuditChangePassword, account.Address, caller, "")
+	return nil
+}
+
+// Accounts 返回文件夹中的账户，按照创建时间排序
+func (m *KeystoreManager) Accounts() []KeystoreAccount {
+	list := []KeystoreAccount{}
+	for _, account := range m.ks.Accounts() {
+		list = append(list, KeystoreAccount{
+			Address:    account.Address,
+			File:       account.URL.Path,
+			CreateTime: keyFileTime(account.URL.Path),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreateTime.Before(list[j].CreateTime)
+	})
+	return list
+}
+
+// geth 生成的 keystore 文件名是 UTC--2022-03-04T02-26-48.286854900Z--<地址>
+func keyFileTime(path string) time.Time {
+	parts := strings.Split(filepath.Base(path), "--")
+	if len(parts) == 3 && parts[0] == "UTC" {
+		if createTime, err := time.Parse("2006-01-02T15-04-05.999999999Z0700", parts[1]); err == nil {
+			return createTime
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// DeleteAccount 删除账户的 keystore 文件，需要账户的密码，并且 confirm 要再输入一次要删除的地址，
+// 删除的账户如果已经解锁会被立即锁定
+func (m *KeystoreManager) DeleteAccount(address, password, confirm, caller string) error {
+	if !common.IsHexAddress(confirm) || common.HexToAddress(confirm) != common.HexToAddress(address) {
+		return ErrDeleteNotConfirmed
+	}
+	account, err := m.find(address)
+	if err != nil {
+		return err
+	}
+	if err := m.ks.Delete(account, password); err != nil {
+		return err
+	}
+	unlockLock.Lock()
+	removeUnlocked(account.Address)
+	unlockLock.Unlock()
+	audit(AuditDelete, account.Address, caller, account.URL.Path)
+	return nil
+}
@@ -0,0 +1,184 @@
+package tool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// RemoteSigner 把签名请求通过 JSON-RPC 转发给外部的签名服务，接口和 Clef 的 account_ 命名空间兼容，
+// 私钥只保存在签名服务中，不会出现在中继服务器上
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	timeout time.Duration // 等待签名服务返回的超时时间，Clef 需要人工确认时要设置得长一些
+}
+
+// NewRemoteSigner 连接签名服务，endpoint 可以是 http 地址，例如 http://127.0.0.1:8550，
+// 也可以是 unix socket 的路径，例如 /var/run/clef/clef.ipc，address 是签名服务中账户的地址
+func NewRemoteSigner(endpoint, address string) (*RemoteSigner, error) {
+	return NewRemoteSignerContext(context.Background(), endpoint, address)
+}
+
+// NewRemoteSignerContext 连接签名服务，ctx 只用于建立连接
+func NewRemoteSignerContext(ctx context.Context, endpoint, address string) (*RemoteSigner, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errors.New("invalid address")
+	}
+	client, err := rpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("连接签名服务 %s 失败 %s", endpoint, err.Error())
+	}
+	return &RemoteSigner{client: client, address: common.HexToAddress(address), timeout: 2 * time.Minute}, nil
+}
+
+// SetTimeout 设置等待签名服务返回的超时时间，默认 2 分钟
+func (s *RemoteSigner) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// Close 断开和签名服务的连接
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
+
+func (s *RemoteSigner) call(result interface{}, method string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if err := s.client.CallContext(ctx, result, method, args...); err != nil {
+		return fmt.Errorf("签名服务 %s 失败 %s", method, err.Error())
+	}
+	return nil
+}
+
+// Accounts 返回签名服务管理的账户，Clef 会要求人工确认
+func (s *RemoteSigner) Accounts() ([]common.Address, error) {
+	var addresses []common.Address
+	if err := s.call(&addresses, "account_list"); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// Clef 的 account_signTransaction 返回的结果
+type remoteSignTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+// SignTx 使用 account_signTransaction 签名交易，签名服务返回的交易会重新检查签名地址和交易内容
+func (s *RemoteSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	if err := checkChainID(tx, chainID); err != nil {
+		return nil, err
+	}
+	data := hexutil.Bytes(tx.Data())
+	args := apitypes.SendTxArgs{
+		From:    common.NewMixedcaseAddress(s.address),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   hexutil.Big(*tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    &data,
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", tx.Type())
+	}
+	result := remoteSignTxResult{}
+	if err := s.call(&result, "account_signTransaction", &args); err != nil {
+		return nil, err
+	}
+	signTx := new(types.Transaction)
+	if err := signTx.UnmarshalBinary(result.Raw); err != nil {
+		return nil, fmt.Errorf("解析签名服务返回的交易失败 %s", err.Error())
+	}
+	if err := checkRemoteTx(tx, signTx, chainID, s.address); err != nil {
+		return nil, err
+	}
+	return signTx, nil
+}
+
+// 签名服务可能被配置错误或者被篡改，发送之前检查返回的交易和请求签名的交易一致
+// Clef 允许人工确认时修改燃料费，所以 gasLimit 和燃料单价只允许降低，提高时可能烧掉热钱包的余额
+func checkRemoteTx(tx, signTx *types.Transaction, chainID *big.Int, address common.Address) error {
+	if signTx.ChainId().Cmp(chainID) != 0 {
+		return fmt.Errorf("签名服务返回的交易 chain id %s 不是 %s", signTx.ChainId().String(), chainID.String())
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(chainID), signTx)
+	if err != nil {
+		return fmt.Errorf("签名服务返回的交易签名无效 %s", err.Error())
+	}
+	if from != address {
+		return fmt.Errorf("签名服务返回的交易签名地址 %s 不是 %s", from.Hex(), address.Hex())
+	}
+	sameTo := (tx.To() == nil && signTx.To() == nil) ||
+		(tx.To() != nil && signTx.To() != nil && *tx.To() == *signTx.To())
+	if signTx.Type() != tx.Type() || signTx.Nonce() != tx.Nonce() || !sameTo ||
+		signTx.Value().Cmp(tx.Value()) != 0 || !bytes.Equal(signTx.Data(), tx.Data()) {
+		return errors.New("签名服务返回的交易和请求签名的交易不一致")
+	}
+	if signTx.Gas() > tx.Gas() || signTx.GasPrice().Cmp(tx.GasPrice()) > 0 ||
+		signTx.GasFeeCap().Cmp(tx.GasFeeCap()) > 0 || signTx.GasTipCap().Cmp(tx.GasTipCap()) > 0 {
+		return errors.New("签名服务返回的交易提高了 gasLimit 或者燃料费")
+	}
+	return nil
+}
+
+// SignMessage 使用 account_signData 按照 text/plain 类型签名消息，和 personal_sign 一致
+func (s *RemoteSigner) SignMessage(message []byte) ([]byte, error) {
+	signature := hexutil.Bytes{}
+	address := common.NewMixedcaseAddress(s.address)
+	if err := s.call(&signature, "account_signData", accounts.MimetypeTextPlain, &address, hexutil.Encode(message)); err != nil {
+		return nil, err
+	}
+	return remoteSignature(signature)
+}
+
+// SignTypedData 使用 account_signTypedData 签名 EIP-712 结构化数据
+func (s *RemoteSigner) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	signature := hexutil.Bytes{}
+	address := common.NewMixedcaseAddress(s.address)
+	if err := s.call(&signature, "account_signTypedData", &address, typedData); err != nil {
+		return nil, err
+	}
+	return remoteSignature(signature)
+}
+
+// Clef 返回的 V 已经是 27 或 28，其它签名服务返回 0 或 1 时统一转换
+func remoteSignature(signature []byte) ([]byte, error) {
+	if len(signature) != 65 {
+		return nil, fmt.Errorf("签名服务返回的签名长度错误 %d", len(signature))
+	}
+	switch signature[64] {
+	case 0, 1:
+		return toPersonalSignature(signature), nil
+	case 27, 28:
+		return signature, nil
+	default:
+		return nil, fmt.Errorf("签名服务返回的签名 V 值错误 %d", signature[64])
+	}
+}
@@ -0,0 +1,158 @@
+package tool
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Signer 是一个地址的签名者，私钥可以在 keystore、内存或者远程的签名服务中
+// SignMessage 和 SignTypedData 返回 65 字节的 [R || S || V] 签名，V 为 27 或 28，和 personal_sign 一致
+type Signer interface {
+	// Address 返回签名者的地址
+	Address() common.Address
+	// SignTx 按照 chainID 签名交易，带类型的交易自身的 chain id 必须和 chainID 一致
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	// SignMessage 按照 EIP-191 签名消息，也就是 personal_sign
+	SignMessage(message []byte) ([]byte, error)
+	// SignTypedData 按照 EIP-712 签名结构化数据
+	SignTypedData(typedData apitypes.TypedData) ([]byte, error)
+}
+
+// 签名之前检查 chain id，不带 chain id 的签名可以在其它链上重放
+func checkChainID(tx *types.Transaction, chainID *big.Int) error {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return errors.New("chain id is required")
+	}
+	if tx.Type() != types.LegacyTxType && tx.ChainId().Cmp(chainID) != 0 {
+		return fmt.Errorf("transaction chain id %s mismatch %s", tx.ChainId().String(), chainID.String())
+	}
+	return nil
+}
+
+// TypedDataHash 计算 EIP-712 结构化数据的签名哈希 keccak256("\x19\x01" || domainSeparator || hashStruct(message))
+func TypedDataHash(typedData apitypes.TypedData) ([]byte, error) {
+	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return nil, err
+	}
+	messageHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256([]byte("\x19\x01"), domainSeparator, messageHash), nil
+}
+
+// 以太坊签名的 V 为 0 或 1，personal_sign 的习惯是 27 或 28
+func toPersonalSignature(signature []byte) []byte {
+	signature[crypto.RecoveryIDOffset] += 27
+	return signature
+}
+
+// PrivateKeySigner 使用内存中的私钥签名，例如 HD 钱包派生出的私钥
+type PrivateKeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewPrivateKeySigner 使用私钥实例化签名者
+func NewPrivateKeySigner(key *ecdsa.PrivateKey) *PrivateKeySigner {
+	return &PrivateKeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// NewPrivateKeySignerFromHex 使用十六进制的私钥实例化签名者，可以带 0x 前缀
+func NewPrivateKeySignerFromHex(hexKey string) (*PrivateKeySigner, error) {
+	key, err := crypto.HexToECDSA(common.Bytes2Hex(common.FromHex(hexKey)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %s", err.Error())
+	}
+	return NewPrivateKeySigner(key), nil
+}
+
+func (s *PrivateKeySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *PrivateKeySigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	if err := checkChainID(tx, chainID); err != nil {
+		return nil, err
+	}
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+func (s *PrivateKeySigner) SignMessage(message []byte) ([]byte, error) {
+	signature, err := crypto.Sign(accounts.TextHash(message), s.key)
+	if err != nil {
+		return nil, err
+	}
+	return toPersonalSignature(signature), nil
+}
+
+func (s *PrivateKeySigner) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := crypto.Sign(hash, s.key)
+	if err != nil {
+		return nil, err
+	}
+	return toPersonalSignature(signature), nil
+}
+
+// KeystoreSigner 使用 keystore 中已经解锁的账户签名
+type KeystoreSigner struct {
+	ks      *keystore.KeyStore
+	account accounts.Account
+}
+
+// NewKeystoreSigner 使用 keystore 中的账户实例化签名者，账户需要在签名之前解锁
+func NewKeystoreSigner(ks *keystore.KeyStore, address string) (*KeystoreSigner, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errors.New("invalid address")
+	}
+	account, err := ks.Find(accounts.Account{Address: common.HexToAddress(address)})
+	if err != nil {
+		return nil, fmt.Errorf("account %s not found in keystore: %s", address, err.Error())
+	}
+	return &KeystoreSigner{ks: ks, account: account}, nil
+}
+
+func (s *KeystoreSigner) Address() common.Address {
+	return s.account.Address
+}
+
+func (s *KeystoreSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	if err := checkChainID(tx, chainID); err != nil {
+		return nil, err
+	}
+	return s.ks.SignTx(s.account, tx, chainID)
+}
+
+func (s *KeystoreSigner) SignMessage(message []byte) ([]byte, error) {
+	signature, err := s.ks.SignHash(s.account, accounts.TextHash(message))
+	if err != nil {
+		return nil, err
+	}
+	return toPersonalSignature(signature), nil
+}
+
+func (s *KeystoreSigner) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.ks.SignHash(s.account, hash)
+	if err != nil {
+		return nil, err
+	}
+	return toPersonalSignature(signature), nil
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // 根据代币的 decimal 得出乘上 10^decimal 后的值
-// value 是包含浮点数的，例如 0.5 个 ETH
+// value 是包含浮点数的，例如 0.5 个 ETH，小数位数超过 decimal 时返回空字符串
 func GetRealDecimalValue(value string, decimal int) string {
 	if strings.Contains(value, ".") {
 		// 小数
@@ -18,6 +18,10 @@ func GetRealDecimalValue(value string, decimal int) string {
 		}
 		num := len(arr[1])
 		left := decimal - num
+		if left < 0 {
+			// 超出代币精度的部分无法转账
+			return ""
+		}
 		return arr[0] + arr[1] + strings.Repeat("0", left)
 	} else {
 		// 整数
@@ -25,6 +29,29 @@ func GetRealDecimalValue(value string, decimal int) string {
 	}
 }
 
+// 和 GetRealDecimalValue 相反，把没有除以 10^decimal 的十进制整数转换为带小数点的值，去掉末尾的 0
+// 例如 decimal 为 6 时 1500000 转换为 1.5
+func FormatDecimalValue(value string, decimal int) string {
+	valueBig, ok := new(big.Int).SetString(value, 10)
+	if !ok || decimal < 0 {
+		return ""
+	}
+	negative := valueBig.Sign() < 0
+	digits := new(big.Int).Abs(valueBig).String()
+	if len(digits) <= decimal {
+		digits = strings.Repeat("0", decimal-len(digits)+1) + digits
+	}
+	integer, fraction := digits[:len(digits)-decimal], strings.TrimRight(digits[len(digits)-decimal:], "0")
+	result := integer
+	if fraction != "" {
+		result += "." + fraction
+	}
+	if negative {
+		result = "-" + result
+	}
+	return result
+}
+
 // 构建符合 ERC20 标准的 transfer 合约函数的 data 入参
 func BuildERC20TransferData(value, receiver string, decimal int) string {
 	realValue := GetRealDecimalValue(value, decimal) // 将 value 乘上 10^decimal的格式
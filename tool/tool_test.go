@@ -1,13 +1,29 @@
 package tool
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 // 单元测试：生成 methodId
@@ -52,7 +68,7 @@ func Test_UnlockETHWallet(t *testing.T) {
 		1000,                      // gasLimit
 		new(big.Int).SetInt64(20), // gasPrice
 		[]byte("交易"))              // data
-	signTx, err := SignETHTransaction(address, tx)
+	signTx, err := SignETHTransaction(address, tx, big.NewInt(1))
 	if err != nil {
 		fmt.Println("签名失败!", err.Error())
 		return
@@ -61,3 +77,545 @@ func Test_UnlockETHWallet(t *testing.T) {
 	fmt.Println("签名成功\n", string(data))
 }
 
+// 单元测试：按照 chain id 签名，普通交易使用 EIP-155，带类型的交易的 chain id 必须一致
+func Test_SignETHTransactionChainID(t *testing.T) {
+	ks := keystore.NewKeyStore(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	oldKs, oldMap := UnlockKs, ETHUnlockMap
+	defer func() {
+		UnlockKs, ETHUnlockMap = oldKs, oldMap
+	}()
+	address := account.Address.String()
+	UnlockKs = ks
+	ETHUnlockMap = map[string]accounts.Account{address: account}
+
+	tx := types.NewTransaction(1, common.Address{}, big.NewInt(10), 21000, big.NewInt(20), nil)
+	if _, err := SignETHTransaction(address, tx, nil); err == nil {
+		t.Fatal("没有 chain id 时应该拒绝签名")
+	}
+	signTx, err := SignETHTransaction(address, tx, big.NewInt(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signTx.Protected() || signTx.ChainId().Int64() != 5 {
+		t.Fatalf("没有使用 EIP-155 签名 %v", signTx.ChainId())
+	}
+	dynamicTx := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(1), Nonce: 1, Gas: 21000, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2)})
+	if _, err := SignETHTransaction(address, dynamicTx, big.NewInt(5)); err == nil {
+		t.Fatal("chain id 不一致时应该拒绝签名")
+	}
+	signTx, err = SignETHTransaction(address, dynamicTx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), signTx)
+	if err != nil || from != account.Address {
+		t.Fatalf("签名错误 %v %v", from, err)
+	}
+}
+
+// 单元测试：把代币的最小单位转换为带小数点的值
+func Test_FormatDecimalValue(t *testing.T) {
+	cases := map[string]string{
+		"1500000": "1.5",
+		"1000000": "1",
+		"25":      "0.000025",
+		"0":       "0",
+		"-15":     "-0.000015",
+	}
+	for value, expected := range cases {
+		if result := FormatDecimalValue(value, 6); result != expected {
+			t.Fatalf("%s 转换错误 %s，应该是 %s", value, result, expected)
+		}
+	}
+	if FormatDecimalValue("12", 0) != "12" || FormatDecimalValue("abc", 6) != "" {
+		t.Fatal("转换错误")
+	}
+	if GetRealDecimalValue("0.1234567", 6) != "" {
+		t.Fatal("超过精度的小数应该返回空字符串")
+	}
+}
+
+// 单元测试：BIP-39 助记词派生 BIP-44 地址，扩展公钥派生的地址和私钥派生的一致
+func Test_HDWallet(t *testing.T) {
+	// BIP-39 的测试助记词
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	wallet, err := NewHDWallet(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := wallet.Address(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.Hex() != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Fatalf("m/44'/60'/0'/0/0 的地址错误 %s", address.Hex())
+	}
+	if _, err := NewHDWallet("abandon abandon abandon", ""); err == nil {
+		t.Fatal("错误的助记词应该返回错误")
+	}
+	created, err := NewMnemonic(128)
+	if err != nil || len(strings.Fields(created)) != 12 {
+		t.Fatalf("生成助记词错误 %s %v", created, err)
+	}
+
+	xpub, err := wallet.AccountXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := NewXPubWallet(xpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint32{0, 1, 1000} {
+		expected, _ := wallet.Address(index)
+		derived, err := watch.Address(index)
+		if err != nil || derived != expected {
+			t.Fatalf("第 %d 个地址不一致 %s %s %v", index, derived.Hex(), expected.Hex(), err)
+		}
+	}
+	if _, err := NewXPubWallet(wallet.account.String()); err == nil {
+		t.Fatal("扩展私钥应该被拒绝")
+	}
+
+	// 解锁派生账户后签名
+	unlocked, err := UnlockHDAccount(wallet, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	signTx, err := SignETHTransaction(strings.ToLower(unlocked), tx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), signTx)
+	if err != nil || from.Hex() != unlocked {
+		t.Fatalf("签名错误 %s %v", from.Hex(), err)
+	}
+}
+
+// 单元测试：私钥和 keystore 签名者的交易、消息和 EIP-712 签名
+func Test_Signer(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ks := keystore.NewKeyStore(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.ImportECDSA(key, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystoreSigner, err := NewKeystoreSigner(ks, account.Address.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeySigner, err := NewPrivateKeySignerFromHex(hexutil.Encode(crypto.FromECDSA(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	if _, err := keystoreSigner.SignTx(tx, big.NewInt(1)); err == nil {
+		t.Fatal("没有解锁时应该拒绝签名")
+	}
+	if err := ks.Unlock(account, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	typedData := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {{Name: "name", Type: "string"}, {Name: "chainId", Type: "uint256"}},
+			"Withdraw":     {{Name: "to", Type: "address"}, {Name: "amount", Type: "uint256"}},
+		},
+		PrimaryType: "Withdraw",
+		Domain:      apitypes.TypedDataDomain{Name: "eth-relay", ChainId: math.NewHexOrDecimal256(1)},
+		Message:     apitypes.TypedDataMessage{"to": "0x3333333333333333333333333333333333333333", "amount": "100"},
+	}
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signer := range []Signer{keystoreSigner, privateKeySigner} {
+		if signer.Address() != account.Address {
+			t.Fatalf("地址错误 %s", signer.Address().Hex())
+		}
+		if _, err := signer.SignTx(tx, nil); err == nil {
+			t.Fatal("没有 chain id 时应该拒绝签名")
+		}
+		signTx, err := signer.SignTx(tx, big.NewInt(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(1)), signTx); err != nil || from != account.Address {
+			t.Fatalf("交易签名错误 %s %v", from.Hex(), err)
+		}
+		// 消息签名的 V 是 27 或 28，恢复时减去 27
+		checkSignature := func(hash, signature []byte) {
+			t.Helper()
+			if len(signature) != 65 || signature[64] < 27 {
+				t.Fatalf("签名格式错误 %x", signature)
+			}
+			recovered := append([]byte{}, signature...)
+			recovered[64] -= 27
+			publicKey, err := crypto.SigToPub(hash, recovered)
+			if err != nil || crypto.PubkeyToAddress(*publicKey) != account.Address {
+				t.Fatalf("签名错误 %v", err)
+			}
+		}
+		signature, err := signer.SignMessage([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSignature(accounts.TextHash([]byte("hello")), signature)
+		signature, err = signer.SignTypedData(typedData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSignature(hash, signature)
+	}
+}
+
+// 模拟 Clef 的 account_ 命名空间，tamper 不为 nil 时在签名之前修改交易
+type testClefService struct {
+	signer *PrivateKeySigner
+	tamper func(args *apitypes.SendTxArgs)
+}
+
+func (s *testClefService) List() []common.Address {
+	return []common.Address{s.signer.Address()}
+}
+
+func (s *testClefService) SignTransaction(args apitypes.SendTxArgs, methodSelector *string) (map[string]interface{}, error) {
+	if args.From.Address() != s.signer.Address() {
+		return nil, errors.New("unknown account")
+	}
+	if s.tamper != nil {
+		s.tamper(&args)
+	}
+	signTx, err := s.signer.SignTx(args.ToTransaction(), args.ChainID.ToInt())
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signTx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"raw": hexutil.Bytes(raw), "tx": signTx}, nil
+}
+
+func (s *testClefService) SignData(contentType string, addr common.MixedcaseAddress, data hexutil.Bytes) (hexutil.Bytes, error) {
+	if contentType != accounts.MimetypeTextPlain || addr.Address() != s.signer.Address() {
+		return nil, errors.New("request denied")
+	}
+	return s.signer.SignMessage(data)
+}
+
+func (s *testClefService) SignTypedData(addr common.MixedcaseAddress, typedData apitypes.TypedData) (hexutil.Bytes, error) {
+	return s.signer.SignTypedData(typedData)
+}
+
+// 单元测试：通过 http 和 unix socket 使用远程签名服务签名
+func Test_RemoteSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	service := &testClefService{signer: NewPrivateKeySigner(key)}
+	server := rpc.NewServer()
+	if err := server.RegisterName("account", service); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	ipcPath := filepath.Join(t.TempDir(), "clef.ipc")
+	listener, err := net.Listen("unix", ipcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeListener(listener)
+	defer listener.Close()
+
+	address := service.signer.Address()
+	to := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	for _, endpoint := range []string{httpServer.URL, ipcPath} {
+		signer, err := NewRemoteSigner(endpoint, address.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accounts, err := signer.Accounts(); err != nil || len(accounts) != 1 || accounts[0] != address {
+			t.Fatalf("账户列表错误 %v %v", accounts, err)
+		}
+		txs := []*types.Transaction{
+			types.NewTransaction(3, to, big.NewInt(1), 21000, big.NewInt(10), []byte{0x01}),
+			types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(5), Nonce: 4, To: &to, Gas: 21000,
+				GasFeeCap: big.NewInt(20), GasTipCap: big.NewInt(2), Value: big.NewInt(1)}),
+		}
+		for _, tx := range txs {
+			signTx, err := signer.SignTx(tx, big.NewInt(5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(5)), signTx); err != nil || from != address {
+				t.Fatalf("交易签名错误 %s %v", from.Hex(), err)
+			}
+			if expected, _ := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(5)), key); signTx.Hash() != expected.Hash() {
+				t.Fatal("签名后的交易内容错误")
+			}
+		}
+		signature, err := signer.SignMessage([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, _ := service.signer.SignMessage([]byte("hello")); !bytes.Equal(signature, expected) {
+			t.Fatalf("消息签名错误 %x", signature)
+		}
+		// 签名服务篡改交易时拒绝使用返回的交易，txs[0] 是传统交易，txs[1] 是 EIP-1559 交易
+		tampers := []struct {
+			tx     *types.Transaction
+			tamper func(args *apitypes.SendTxArgs)
+		}{
+			{txs[0], func(args *apitypes.SendTxArgs) {
+				to := common.NewMixedcaseAddress(common.HexToAddress("0x4444444444444444444444444444444444444444"))
+				args.To = &to
+			}},
+			{txs[0], func(args *apitypes.SendTxArgs) { args.Gas++ }},
+			{txs[0], func(args *apitypes.SendTxArgs) { args.GasPrice = (*hexutil.Big)(big.NewInt(1000)) }},
+			{txs[1], func(args *apitypes.SendTxArgs) { args.MaxFeePerGas = (*hexutil.Big)(big.NewInt(1000)) }},
+			{txs[1], func(args *apitypes.SendTxArgs) { args.MaxPriorityFeePerGas = (*hexutil.Big)(big.NewInt(3)) }},
+			{txs[1], func(args *apitypes.SendTxArgs) { args.ChainID = (*hexutil.Big)(big.NewInt(1)) }},
+		}
+		for i, c := range tampers {
+			service.tamper = c.tamper
+			if _, err := signer.SignTx(c.tx, big.NewInt(5)); err == nil {
+				t.Fatalf("签名服务篡改交易 %d 时应该返回错误", i)
+			}
+		}
+		// 人工确认时降低燃料费是允许的
+		service.tamper = func(args *apitypes.SendTxArgs) { args.GasPrice = (*hexutil.Big)(big.NewInt(9)) }
+		if signTx, err := signer.SignTx(txs[0], big.NewInt(5)); err != nil || signTx.GasPrice().Int64() != 9 {
+			t.Fatalf("降低燃料费的交易应该可以使用 %v", err)
+		}
+		service.tamper = nil
+		signer.Close()
+	}
+	// 签名服务中没有的账户
+	signer, err := NewRemoteSigner(httpServer.URL, "0x5555555555555555555555555555555555555555")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	if _, err := signer.SignMessage([]byte("hello")); err == nil {
+		t.Fatal("签名服务拒绝时应该返回错误")
+	}
+}
+
+// 保存在内存中的审计日志
+type testAuditLogger struct {
+	lock   sync.Mutex
+	events []AuditEvent
+}
+
+func (l *testAuditLogger) Audit(event AuditEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = append(l.events, event)
+}
+
+// 返回最后一条审计日志
+func (l *testAuditLogger) last() AuditEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.events) == 0 {
+		return AuditEvent{}
+	}
+	return l.events[len(l.events)-1]
+}
+
+// 单元测试：定时解锁到期后自动锁定，主动锁定，并记录审计日志
+func Test_UnlockLifecycle(t *testing.T) {
+	keysDir := t.TempDir()
+	account, err := keystore.NewKeyStore(keysDir, keystore.LightScryptN, keystore.LightScryptP).NewAccount("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKs, oldMap := UnlockKs, ETHUnlockMap
+	logger := &testAuditLogger{}
+	SetAuditLogger(logger)
+	defer func() {
+		UnlockKs, ETHUnlockMap = oldKs, oldMap
+		SetAuditLogger(defaultAuditLogger)
+	}()
+	UnlockKs, ETHUnlockMap = nil, nil
+	address := account.Address.String()
+	tx := types.NewTransaction(1, common.Address{}, big.NewInt(10), 21000, big.NewInt(20), nil)
+	checkEvent := func(action, caller string) {
+		t.Helper()
+		if event := logger.last(); event.Action != action || event.Caller != caller || event.Address != address {
+			t.Fatalf("审计日志错误 %+v", event)
+		}
+	}
+
+	if err := TimedUnlockETHWallet(keysDir, address, "789", time.Minute, "alice"); err == nil {
+		t.Fatal("密码错误时应该返回错误")
+	}
+	checkEvent(AuditUnlockFailed, "alice")
+	if err := TimedUnlockETHWallet(keysDir, address, "123456", 100*time.Millisecond, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	checkEvent(AuditUnlock, "alice")
+	// 其它测试解锁的账户也在列表中
+	findUnlocked := func(address common.Address) *UnlockedAccount {
+		for _, unlocked := range UnlockedAccounts() {
+			if unlocked.Address == address {
+				return &unlocked
+			}
+		}
+		return nil
+	}
+	if unlocked := findUnlocked(account.Address); unlocked == nil || unlocked.Caller != "alice" || unlocked.ExpireTime.IsZero() {
+		t.Fatalf("解锁的账户错误 %+v", unlocked)
+	}
+	signTx, err := SignETHTransactionAs("dave", address, tx, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvent(AuditSign, "dave") // 签名事件记录发起签名的调用者，不是解锁账户的调用者
+	if logger.last().Detail != "transaction "+signTx.Hash().Hex() {
+		t.Fatalf("签名的审计日志错误 %s", logger.last().Detail)
+	}
+	// 到期后自动锁定
+	for deadline := time.Now().Add(5 * time.Second); logger.last().Action != AuditExpire; {
+		if time.Now().After(deadline) {
+			t.Fatal("定时解锁没有到期")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkEvent(AuditExpire, "alice")
+	if findUnlocked(account.Address) != nil {
+		t.Fatal("到期后账户应该被锁定")
+	}
+	if _, err := SignETHTransaction(address, tx, big.NewInt(1)); err == nil {
+		t.Fatal("到期后应该拒绝签名")
+	}
+
+	// 一直解锁的账户需要主动锁定
+	if err := UnlockETHWallet(keysDir, address, "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if unlocked := findUnlocked(account.Address); unlocked == nil || !unlocked.ExpireTime.IsZero() {
+		t.Fatalf("解锁的账户错误 %+v", unlocked)
+	}
+	LockETHWallet(strings.ToLower(address), "bob")
+	checkEvent(AuditLock, "bob")
+	if _, err := SignETHTransaction(address, tx, big.NewInt(1)); err == nil {
+		t.Fatal("锁定后应该拒绝签名")
+	}
+	if _, err := UnlockKs.SignTx(account, tx, big.NewInt(1)); err == nil {
+		t.Fatal("锁定后 keystore 中的私钥应该被清除")
+	}
+
+	// HD 钱包账户
+	wallet, err := NewHDWallet("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdAddress, err := TimedUnlockHDAccount(wallet, 0, time.Minute, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignETHTransactionAs("erin", hdAddress, tx, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if event := logger.last(); event.Action != AuditSign || event.Caller != "erin" {
+		t.Fatalf("审计日志错误 %+v", event)
+	}
+	// 锁定之前取得的签名者在锁定之后也不能再签名
+	signer, err := UnlockedSigner(hdAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LockETHWallet(hdAddress, "carol")
+	if _, err := SignETHTransaction(hdAddress, tx, big.NewInt(1)); err == nil || findUnlocked(common.HexToAddress(hdAddress)) != nil {
+		t.Fatal("锁定后应该拒绝签名")
+	}
+	if _, err := signer.SignTx(tx, big.NewInt(1)); err == nil {
+		t.Fatal("锁定后之前取得的签名者应该拒绝签名")
+	}
+	if _, err := signer.SignMessage([]byte("hello")); err == nil {
+		t.Fatal("锁定后之前取得的签名者应该拒绝签名")
+	}
+}
+
+// 单元测试：导入、导出、修改密码、列出和删除 keystore 账户
+func Test_KeystoreManager(t *testing.T) {
+	logger := &testAuditLogger{}
+	SetAuditLogger(logger)
+	defer SetAuditLogger(defaultAuditLogger)
+	manager := NewKeystoreManager(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	key, _ := crypto.GenerateKey()
+	address, err := manager.ImportPrivateKey(hexutil.Encode(crypto.FromECDSA(key)), "123456", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != crypto.PubkeyToAddress(key.PublicKey) || logger.last().Action != AuditImport {
+		t.Fatalf("导入私钥错误 %s", address.Hex())
+	}
+	if _, err := manager.ImportPrivateKey(hexutil.Encode(crypto.FromECDSA(key)), "123456", "alice"); err == nil {
+		t.Fatal("重复导入应该返回错误")
+	}
+	if _, err := manager.ImportPrivateKey("0x1234", "123456", "alice"); err == nil {
+		t.Fatal("私钥格式错误时应该返回错误")
+	}
+
+	// 修改密码后旧密码不能再使用
+	if err := manager.ChangePassword(address.Hex(), "789", "654321", "alice"); err == nil {
+		t.Fatal("密码错误时应该返回错误")
+	}
+	if err := manager.ChangePassword(address.Hex(), "123456", "654321", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.ExportKeystore(address.Hex(), "123456", "abc", "bob"); err == nil {
+		t.Fatal("修改密码后旧密码应该失效")
+	}
+	keyJSON, err := manager.ExportKeystore(address.Hex(), "654321", "abc", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := logger.last(); event.Action != AuditExport || event.Caller != "bob" {
+		t.Fatalf("审计日志错误 %+v", event)
+	}
+
+	// 导出的 keystore 导入到另一个文件夹
+	other := NewKeystoreManager(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	if _, err := other.ImportKeystore(keyJSON, "654321", "abc", "carol"); err == nil {
+		t.Fatal("导出的 keystore 应该使用新密码加密")
+	}
+	if imported, err := other.ImportKeystore(keyJSON, "abc", "def", "carol"); err != nil || imported != address {
+		t.Fatalf("导入 keystore 错误 %s %v", imported.Hex(), err)
+	}
+
+	list := manager.Accounts()
+	if len(list) != 1 || list[0].Address != address || time.Since(list[0].CreateTime) > time.Minute {
+		t.Fatalf("账户列表错误 %+v", list)
+	}
+	if keyFileTime("UTC--2022-03-04T02-26-48.286854900Z--97376cf11717ab4a9e9a94042e895640a6262e30") !=
+		time.Date(2022, 3, 4, 2, 26, 48, 286854900, time.UTC) {
+		t.Fatal("解析 keystore 文件名中的时间错误")
+	}
+
+	// 删除时需要再输入一次地址确认
+	if err := manager.DeleteAccount(address.Hex(), "654321", "0x3333333333333333333333333333333333333333", "alice"); !errors.Is(err, ErrDeleteNotConfirmed) {
+		t.Fatalf("应该返回 ErrDeleteNotConfirmed %v", err)
+	}
+	if err := manager.DeleteAccount(address.Hex(), "123456", strings.ToLower(address.Hex()), "alice"); err == nil {
+		t.Fatal("密码错误时应该拒绝删除")
+	}
+	if err := manager.DeleteAccount(address.Hex(), "654321", strings.ToLower(address.Hex()), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if event := logger.last(); event.Action != AuditDelete || event.Caller != "alice" {
+		t.Fatalf("审计日志错误 %+v", event)
+	}
+	if _, err := os.Stat(list[0].File); !os.IsNotExist(err) || len(manager.Accounts()) != 0 {
+		t.Fatal("删除后 keystore 文件应该不存在")
+	}
+}
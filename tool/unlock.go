@@ -0,0 +1,205 @@
+package tool
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// 审计日志的事件类型
+const (
+	AuditUnlock       = "unlock"        // 解锁成功
+	AuditUnlockFailed = "unlock_failed" // 解锁失败，例如密码错误
+	AuditSign         = "sign"          // 签名交易、消息或者结构化数据
+	AuditLock         = "lock"          // 调用 LockETHWallet 锁定
+	AuditExpire       = "expire"        // 定时解锁到期后自动锁定
+)
+
+// AuditEvent 是一条钱包的审计日志
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Address string    `json:"address"`
+	// 调用者的身份，例如操作员或者服务的名称。签名事件是发起签名的调用者，到期事件是解锁账户的调用者
+	Caller string `json:"caller"`
+	Detail string `json:"detail,omitempty"` // 签名的交易哈希值、解锁失败的原因等
+}
+
+// AuditLogger 记录钱包的审计日志，会在多个协程中调用
+type AuditLogger interface {
+	Audit(event AuditEvent)
+}
+
+// 每条审计日志编码为一行 json
+type jsonAuditLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewJSONAuditLogger 把审计日志按行写入 w，例如只追加的日志文件
+func NewJSONAuditLogger(w io.Writer) AuditLogger {
+	return &jsonAuditLogger{w: w}
+}
+
+func (l *jsonAuditLogger) Audit(event AuditEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, _ = l.w.Write(append(data, '\n'))
+}
+
+// 默认的审计日志写入标准错误输出，保证没有配置时解锁、签名和锁定事件也不会丢失
+var defaultAuditLogger = NewJSONAuditLogger(os.Stderr)
+
+var (
+	auditLock   sync.RWMutex
+	auditLogger = defaultAuditLogger
+)
+
+// SetAuditLogger 设置钱包的审计日志，默认按行写入标准错误输出
+// 需要合规审计的部署应该在解锁钱包之前设置，例如写入只追加的日志文件，传入 nil 时不再记录
+func SetAuditLogger(logger AuditLogger) {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	auditLogger = logger
+}
+
+func audit(action string, address common.Address, caller, detail string) {
+	auditLock.RLock()
+	logger := auditLogger
+	auditLock.RUnlock()
+	if logger != nil {
+		logger.Audit(AuditEvent{Time: time.Now(), Action: action, Address: address.String(), Caller: caller, Detail: detail})
+	}
+}
+
+// UnlockedAccount 是一个当前已经解锁的账户
+type UnlockedAccount struct {
+	Address    common.Address
+	Caller     string    // 解锁账户的调用者
+	UnlockTime time.Time // 解锁的时间
+	ExpireTime time.Time // 自动锁定的时间，零值代表一直解锁，直到调用 LockETHWallet
+}
+
+type unlockSession struct {
+	UnlockedAccount
+	timer *time.Timer
+}
+
+// 解锁的账户，keystore 账户和 HD 钱包账户共用，受 unlockLock 保护
+var unlockSessions = map[common.Address]*unlockSession{}
+
+// 记录新的解锁，同一个账户重新解锁时以最后一次为准，调用时需要持有 unlockLock 的写锁
+func startUnlockSession(address common.Address, caller string, timeout time.Duration) {
+	if old, ok := unlockSessions[address]; ok && old.timer != nil {
+		old.timer.Stop()
+	}
+	session := &unlockSession{UnlockedAccount: UnlockedAccount{Address: address, Caller: caller, UnlockTime: time.Now()}}
+	if timeout > 0 {
+		session.ExpireTime = session.UnlockTime.Add(timeout)
+		session.timer = time.AfterFunc(timeout, func() {
+			unlockLock.Lock()
+			defer unlockLock.Unlock()
+			if unlockSessions[address] != session {
+				return // 已经被锁定或者重新解锁
+			}
+			removeUnlocked(address)
+			audit(AuditExpire, address, caller, "")
+		})
+	}
+	unlockSessions[address] = session
+}
+
+// 从全局的解锁状态中删除账户，调用时需要持有 unlockLock 的写锁
+func removeUnlocked(address common.Address) {
+	if session, ok := unlockSessions[address]; ok && session.timer != nil {
+		session.timer.Stop()
+	}
+	delete(unlockSessions, address)
+	delete(hdUnlockMap, address.String())
+	for key := range ETHUnlockMap {
+		// ETHUnlockMap 的 key 是解锁时传入的地址，大小写可能不同
+		if common.HexToAddress(key) == address {
+			delete(ETHUnlockMap, key)
+		}
+	}
+	if UnlockKs != nil {
+		_ = UnlockKs.Lock(address)
+	}
+}
+
+// LockETHWallet 立即锁定账户，keystore 中的私钥从内存中清除，HD 钱包账户需要重新解锁才能签名
+// 账户没有解锁时直接返回
+func LockETHWallet(address, caller string) {
+	account := common.HexToAddress(address)
+	unlockLock.Lock()
+	defer unlockLock.Unlock()
+	removeUnlocked(account)
+	audit(AuditLock, account, caller, "")
+}
+
+// UnlockedAccounts 返回当前已经解锁的账户，按照解锁时间排序
+func UnlockedAccounts() []UnlockedAccount {
+	unlockLock.RLock()
+	defer unlockLock.RUnlock()
+	now := time.Now()
+	unlocked := make([]UnlockedAccount, 0, len(unlockSessions))
+	for _, session := range unlockSessions {
+		if session.ExpireTime.IsZero() || session.ExpireTime.After(now) {
+			unlocked = append(unlocked, session.UnlockedAccount)
+		}
+	}
+	sort.Slice(unlocked, func(i, j int) bool {
+		return unlocked[i].UnlockTime.Before(unlocked[j].UnlockTime)
+	})
+	return unlocked
+}
+
+// 签名成功后记录审计日志的 Signer
+type auditSigner struct {
+	Signer
+	caller string
+}
+
+// NewAuditSigner 包装 signer，每次签名成功后把 caller 作为发起签名的调用者记录到审计日志中
+func NewAuditSigner(signer Signer, caller string) Signer {
+	return &auditSigner{Signer: signer, caller: caller}
+}
+
+func (s *auditSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signTx, err := s.Signer.SignTx(tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+	audit(AuditSign, s.Address(), s.caller, "transaction "+signTx.Hash().Hex())
+	return signTx, nil
+}
+
+func (s *auditSigner) SignMessage(message []byte) ([]byte, error) {
+	signature, err := s.Signer.SignMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	audit(AuditSign, s.Address(), s.caller, "message")
+	return signature, nil
+}
+
+func (s *auditSigner) SignTypedData(typedData apitypes.TypedData) ([]byte, error) {
+	signature, err := s.Signer.SignTypedData(typedData)
+	if err != nil {
+		return nil, err
+	}
+	audit(AuditSign, s.Address(), s.caller, "typed data "+typedData.PrimaryType)
+	return signature, nil
+}
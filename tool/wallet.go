@@ -2,6 +2,9 @@ package tool
 
 import (
 	"errors"
+	"math/big"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi"
@@ -16,8 +19,20 @@ var ETHUnlockMap map[string]accounts.Account
 // 全局地对应 keystore 实例
 var UnlockKs *keystore.KeyStore
 
+// 保护上面两个全局变量和已经解锁的 HD 钱包私钥，解锁和签名可能在不同的协程中
+var unlockLock sync.RWMutex
+
 // 解锁以太坊钱包，传入钱包地址和对应的 keystore 密码
+// 解锁后一直有效，直到调用 LockETHWallet，热钱包应该使用 TimedUnlockETHWallet
 func UnlockETHWallet(keysDir string, address, password string) error {
+	return TimedUnlockETHWallet(keysDir, address, password, 0, "")
+}
+
+// TimedUnlockETHWallet 解锁以太坊钱包，timeout 之后自动锁定，timeout 为 0 时一直解锁
+// caller 是调用者的身份，和解锁、签名、锁定事件一起记录到审计日志中
+func TimedUnlockETHWallet(keysDir string, address, password string, timeout time.Duration, caller string) error {
+	unlockLock.Lock()
+	defer unlockLock.Unlock()
 	if UnlockKs == nil {
 		UnlockKs = keystore.NewKeyStore(
 			// 服务端存储 keystore 文件的文件夹
@@ -30,17 +45,27 @@ func UnlockETHWallet(keysDir string, address, password string) error {
 		}
 	}
 	unlock := accounts.Account{Address: common.HexToAddress(address)}
-	// ks.Unlock 调用 keystore.go 的解锁函数，解锁出的私钥将存储再它里面的变量中
-	if err := UnlockKs.Unlock(unlock, password); nil != err {
+	// ks.TimedUnlock 调用 keystore.go 的解锁函数，解锁出的私钥将存储再它里面的变量中，到期后清除
+	if err := UnlockKs.TimedUnlock(unlock, password, timeout); nil != err {
+		audit(AuditUnlockFailed, unlock.Address, caller, err.Error())
 		return errors.New("unlock err : " + err.Error())
 	}
 	if ETHUnlockMap == nil {
 		ETHUnlockMap = map[string]accounts.Account{}
 	}
 	ETHUnlockMap[address] = unlock // 解锁成功，存储
+	startUnlockSession(unlock.Address, caller, timeout)
+	audit(AuditUnlock, unlock.Address, caller, unlockDetail(timeout))
 	return nil
 }
 
+func unlockDetail(timeout time.Duration) string {
+	if timeout <= 0 {
+		return "no timeout"
+	}
+	return "timeout " + timeout.String()
+}
+
 // 根据函数的名称生成 methodId。abiStr 是智能合约的 "abi" 数据
 func MakeMethodId(methodName string, abiStr string) (string, error) {
 	abi := &abi.ABI{} // 实例化 "ABI" 结构体对象指针
@@ -74,14 +99,39 @@ func MakeMethodId(methodName string, abiStr string) (string, error) {
 // }
 
 // 对交易数据结构体 types.Transaction 进行签名
-func SignETHTransaction(address string, transaction *types.Transaction) (*types.Transaction, error) {
+// chainID 是交易所在链的 chain id，按照交易类型使用 EIP-155、EIP-2930 或者 London 的 signer 签名，
+// 带类型的交易自身的 chain id 必须和 chainID 一致
+// address 可以是 keystore 中解锁的账户，也可以是 UnlockHDAccount 解锁的 HD 钱包账户
+// 审计日志中不知道调用者是谁，需要记录调用者时使用 SignETHTransactionAs
+func SignETHTransaction(address string, transaction *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return SignETHTransactionAs("", address, transaction, chainID)
+}
+
+// SignETHTransactionAs 和 SignETHTransaction 一样对交易签名，caller 是发起签名的调用者，记录到审计日志中
+func SignETHTransactionAs(caller, address string, transaction *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signer, err := UnlockedSigner(address)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditSigner(signer, caller).SignTx(transaction, chainID) // 调用签名函数
+}
+
+// UnlockedSigner 返回使用全局解锁状态签名的 Signer，HD 钱包账户优先
+// 返回的 Signer 每次签名时都检查解锁状态，账户锁定或者解锁到期之后签名会返回错误
+// 返回的 Signer 不记录审计日志，需要时使用 NewAuditSigner 包装
+func UnlockedSigner(address string) (Signer, error) {
+	unlockLock.RLock()
+	defer unlockLock.RUnlock()
+	if _, ok := hdUnlockMap[common.HexToAddress(address).String()]; ok {
+		return &hdUnlockedSigner{address: common.HexToAddress(address)}, nil
+	}
 	if UnlockKs == nil {
 		return nil, errors.New("you need to init keystore first")
 	}
-	account := ETHUnlockMap[address]
-	if !common.IsHexAddress(account.Address.String()) {
+	account, ok := ETHUnlockMap[address]
+	if !ok {
 		// 判断当前的地址钱包是否解锁了
 		return nil, errors.New("account need to unlock first")
 	}
-	return UnlockKs.SignTx(account, transaction, nil) // 调用签名函数
+	return &KeystoreSigner{ks: UnlockKs, account: account}, nil
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"eth-relay/dao"
+	"sort"
+	"sync"
+)
+
+// ErrTxNotTracked 代表交易不在追踪器的存储中
+var ErrTxNotTracked = errors.New("transaction not tracked")
+
+// 交易的追踪状态
+const (
+	TxPending  = "pending"  // 已经广播，还没有达到确认数
+	TxMined    = "mined"    // 已经被打包并达到确认数，执行成功
+	TxFailed   = "failed"   // 已经被打包并达到确认数，执行失败
+	TxDropped  = "dropped"  // 节点交易池中已经没有这笔交易，nonce 也没有被使用
+	TxReplaced = "replaced" // nonce 已经被其它交易使用
+)
+
+// TxStore 是交易追踪器的存储接口
+type TxStore interface {
+	// Insert 保存一笔新广播的交易
+	Insert(tx *dao.TrackedTransaction) error
+	// Get 根据交易哈希值获取交易，不存在时返回 ErrTxNotTracked
+	Get(hash string) (*dao.TrackedTransaction, error)
+	// Pending 按照保存的顺序返回所有 pending 状态的交易
+	Pending() ([]*dao.TrackedTransaction, error)
+	// ByNonce 按照保存的顺序返回同一个地址、同一个 nonce 的所有交易
+	ByNonce(from string, nonce uint64) ([]*dao.TrackedTransaction, error)
+	// Update 更新交易的状态
+	Update(tx *dao.TrackedTransaction) error
+}
+
+// MemTxStore 是保存在内存中的交易存储，重启后丢失
+type MemTxStore struct {
+	lock sync.Mutex
+	txs  map[string]*dao.TrackedTransaction
+	id   int64 // 和数据库的自增主键一样，按照保存的顺序分配
+}
+
+// NewMemTxStore 实例化内存交易存储
+func NewMemTxStore() *MemTxStore {
+	return &MemTxStore{txs: map[string]*dao.TrackedTransaction{}}
+}
+
+func (s *MemTxStore) Insert(tx *dao.TrackedTransaction) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.id++
+	tx.Id = s.id
+	copied := *tx
+	s.txs[tx.Hash] = &copied
+	return nil
+}
+
+func (s *MemTxStore) Get(hash string) (*dao.TrackedTransaction, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tx, ok := s.txs[hash]
+	if !ok {
+		return nil, ErrTxNotTracked
+	}
+	copied := *tx
+	return &copied, nil
+}
+
+func (s *MemTxStore) Pending() ([]*dao.TrackedTransaction, error) {
+	return s.find(func(tx *dao.TrackedTransaction) bool {
+		return tx.Status == TxPending
+	}), nil
+}
+
+func (s *MemTxStore) ByNonce(from string, nonce uint64) ([]*dao.TrackedTransaction, error) {
+	return s.find(func(tx *dao.TrackedTransaction) bool {
+		return tx.FromAddress == from && tx.Nonce == nonce
+	}), nil
+}
+
+func (s *MemTxStore) Update(tx *dao.TrackedTransaction) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.txs[tx.Hash]; !ok {
+		return ErrTxNotTracked
+	}
+	copied := *tx
+	s.txs[tx.Hash] = &copied
+	return nil
+}
+
+// 按照广播的顺序返回符合条件的交易
+func (s *MemTxStore) find(match func(tx *dao.TrackedTransaction) bool) []*dao.TrackedTransaction {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	txs := []*dao.TrackedTransaction{}
+	for _, tx := range s.txs {
+		if match(tx) {
+			copied := *tx
+			txs = append(txs, &copied)
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Id < txs[j].Id
+	})
+	return txs
+}
+
+// SQLTxStore 是保存在数据库中的交易存储，需要同步 dao.TrackedTransaction 表
+type SQLTxStore struct {
+	mysql dao.MySQLConnector
+}
+
+// NewSQLTxStore 实例化数据库交易存储
+func NewSQLTxStore(mysql dao.MySQLConnector) *SQLTxStore {
+	return &SQLTxStore{mysql: mysql}
+}
+
+func (s *SQLTxStore) Insert(tx *dao.TrackedTransaction) error {
+	_, err := s.mysql.Db.Insert(tx)
+	return err
+}
+
+func (s *SQLTxStore) Get(hash string) (*dao.TrackedTransaction, error) {
+	tx := dao.TrackedTransaction{}
+	has, err := s.mysql.Db.Where("hash = ?", hash).Get(&tx)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrTxNotTracked
+	}
+	return &tx, nil
+}
+
+func (s *SQLTxStore) Pending() ([]*dao.TrackedTransaction, error) {
+	txs := []*dao.TrackedTransaction{}
+	err := s.mysql.Db.Where("status = ?", TxPending).Asc("id").Find(&txs)
+	return txs, err
+}
+
+func (s *SQLTxStore) ByNonce(from string, nonce uint64) ([]*dao.TrackedTransaction, error) {
+	txs := []*dao.TrackedTransaction{}
+	err := s.mysql.Db.Where("from_address = ? and nonce = ?", from, nonce).Asc("id").Find(&txs)
+	return txs, err
+}
+
+func (s *SQLTxStore) Update(tx *dao.TrackedTransaction) error {
+	_, err := s.mysql.Db.ID(tx.Id).
+		Cols("status", "block_number", "replaced_by", "missing_time", "update_time").
+		Update(tx)
+	return err
+}
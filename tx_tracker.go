@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"eth-relay/dao"
+	"eth-relay/model"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrTxNotPending 代表交易已经有了最终状态，不能再加速或者取消
+var ErrTxNotPending = errors.New("transaction is not pending")
+
+// ErrTxTrackFailed 代表交易已经广播，但是保存到交易存储失败，这笔交易之后无法加速或者取消
+var ErrTxTrackFailed = errors.New("track transaction failed")
+
+// 取消交易时发给自己的零值转账所使用的 gasLimit
+const cancelGasLimit = 21000
+
+// TransactionTracker 交易追踪器
+// 保存每一笔广播成功的交易，定时查询收据，直到交易达到确认数或者 nonce 被其它交易使用，
+// 卡住的交易可以通过 SpeedUp 提高燃料费重新广播，或者通过 Cancel 用同一个 nonce 的零值自转账取消
+type TransactionTracker struct {
+	requester     *ETHRPCRequester
+	store         TxStore       // 交易存储
+	confirmations uint64        // 确认数，最新区块号 - 交易所在区块号 >= confirmations 时交易才有最终状态
+	bumpPercent   int64         // 加速和取消时燃料费提高的百分比，节点要求替换交易至少提高 10%
+	dropTimeout   time.Duration // 交易不在节点交易池中超过这个时间后标记为 dropped
+	interval      time.Duration // 轮询的间隔
+	onError       func(error)   // 后台轮询出错时调用，为空时忽略
+	stop          chan bool     // 用来控制是否停止轮询的管道
+}
+
+// NewTransactionTracker 实例化交易追踪器，并让 requester 之后广播的交易都保存到 store 中
+func NewTransactionTracker(requester *ETHRPCRequester, store TxStore) *TransactionTracker {
+	tracker := &TransactionTracker{
+		requester:     requester,
+		store:         store,
+		confirmations: 12,
+		bumpPercent:   12,
+		dropTimeout:   30 * time.Minute,
+		interval:      15 * time.Second,
+		stop:          make(chan bool, 1),
+	}
+	requester.SetTransactionTracker(tracker)
+	return tracker
+}
+
+// SetConfirmations 设置确认数
+func (tracker *TransactionTracker) SetConfirmations(n uint64) {
+	tracker.confirmations = n
+}
+
+// SetBumpPercent 设置加速和取消时燃料费提高的百分比，小于 10 时节点会拒绝替换
+func (tracker *TransactionTracker) SetBumpPercent(percent int64) {
+	tracker.bumpPercent = percent
+}
+
+// SetDropTimeout 设置交易不在交易池中多久之后标记为 dropped
+func (tracker *TransactionTracker) SetDropTimeout(timeout time.Duration) {
+	tracker.dropTimeout = timeout
+}
+
+// SetInterval 设置轮询的间隔
+func (tracker *TransactionTracker) SetInterval(interval time.Duration) {
+	tracker.interval = interval
+}
+
+// SetErrorHandler 设置后台轮询出错时的回调，例如写入日志或者告警，需要在 Start 之前设置
+// 回调在轮询协程中调用，不能阻塞太久
+func (tracker *TransactionTracker) SetErrorHandler(handler func(err error)) {
+	tracker.onError = handler
+}
+
+// Start 启动一个协程定时轮询 pending 状态的交易，出错时交给 SetErrorHandler 设置的回调
+func (tracker *TransactionTracker) Start() {
+	go func() {
+		ticker := time.NewTicker(tracker.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tracker.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := tracker.requester.defaultContext()
+				if err := tracker.PollContext(ctx); err != nil && tracker.onError != nil {
+					tracker.onError(fmt.Errorf("轮询交易状态失败 %w", err))
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// Stop 停止轮询
+func (tracker *TransactionTracker) Stop() {
+	tracker.stop <- true
+}
+
+// Get 根据交易哈希值获取追踪的交易，不存在时返回 ErrTxNotTracked
+func (tracker *TransactionTracker) Get(hash string) (*dao.TrackedTransaction, error) {
+	return tracker.store.Get(strings.ToLower(hash))
+}
+
+// 保存一笔广播成功的交易，同一笔交易重复广播时只保存一次
+func (tracker *TransactionTracker) track(address string, signTx *types.Transaction) error {
+	hash := strings.ToLower(signTx.Hash().Hex())
+	if _, err := tracker.store.Get(hash); err == nil {
+		return nil
+	} else if !errors.Is(err, ErrTxNotTracked) {
+		return err
+	}
+	raw, err := signTx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	tx := &dao.TrackedTransaction{
+		Hash:        hash,
+		FromAddress: address, // 保留解锁钱包时使用的写法，加速和取消时用它签名
+		Nonce:       signTx.Nonce(),
+		TxType:      int(signTx.Type()),
+		Value:       signTx.Value().String(),
+		Gas:         signTx.Gas(),
+		RawTx:       hexutil.Encode(raw),
+		Status:      TxPending,
+		CreateTime:  now,
+		UpdateTime:  now,
+	}
+	if signTx.To() != nil {
+		tx.ToAddress = strings.ToLower(signTx.To().Hex())
+	}
+	if signTx.Type() == types.DynamicFeeTxType {
+		tx.GasFeeCap = signTx.GasFeeCap().String()
+		tx.GasTipCap = signTx.GasTipCap().String()
+	} else {
+		tx.GasPrice = signTx.GasPrice().String()
+	}
+	return tracker.store.Insert(tx)
+}
+
+// Poll 查询一次所有 pending 状态的交易，更新达到最终状态的交易
+func (tracker *TransactionTracker) Poll() error {
+	ctx, cancel := tracker.requester.defaultContext()
+	defer cancel()
+	return tracker.PollContext(ctx)
+}
+
+// PollContext 查询一次所有 pending 状态的交易，更新达到最终状态的交易
+// 单笔交易查询失败时继续处理其它交易，返回最后一个错误
+func (tracker *TransactionTracker) PollContext(ctx context.Context) error {
+	pending, err := tracker.store.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	latest, err := tracker.requester.GetLatestBlockNumberContext(ctx)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, tx := range pending {
+		if err := tracker.poll(ctx, tx, latest.Uint64()); err != nil {
+			lastErr = fmt.Errorf("更新交易 %s 失败 %w", tx.Hash, err)
+		}
+	}
+	return lastErr
+}
+
+// 更新一笔交易的状态，latest 是最新区块号
+func (tracker *TransactionTracker) poll(ctx context.Context, tx *dao.TrackedTransaction, latest uint64) error {
+	// 同一个 nonce 的其它交易可能已经在这一轮中把它标记为 replaced
+	current, err := tracker.store.Get(tx.Hash)
+	if err != nil {
+		return err
+	}
+	if current.Status != TxPending {
+		return nil
+	}
+	receipt, err := tracker.requester.GetTransactionReceiptContext(ctx, tx.Hash)
+	if err == nil {
+		return tracker.confirm(tx, receipt, latest)
+	}
+	if !errors.Is(err, ErrReceiptNotFound) {
+		return err
+	}
+	// 没有收据，先看 nonce 是否已经被其它交易使用
+	used, err := tracker.requester.GetNonceAtContext(ctx, tx.FromAddress, LatestBlock)
+	if err != nil {
+		return err
+	}
+	siblings, err := tracker.store.ByNonce(tx.FromAddress, tx.Nonce)
+	if err != nil {
+		return err
+	}
+	if used > tx.Nonce {
+		for _, sibling := range siblings {
+			if sibling.Hash == tx.Hash {
+				continue
+			}
+			if _, err := tracker.requester.GetTransactionReceiptContext(ctx, sibling.Hash); err == nil {
+				return tracker.update(tx, TxReplaced, sibling.Hash)
+			}
+		}
+		// 查询收据和查询 nonce 之间交易可能刚好被打包，再查一次自己的收据
+		receipt, err := tracker.requester.GetTransactionReceiptContext(ctx, tx.Hash)
+		if err == nil {
+			return tracker.confirm(tx, receipt, latest)
+		}
+		if !errors.Is(err, ErrReceiptNotFound) {
+			return err
+		}
+		// 收据可能还没有同步到这个节点，或者 nonce 被没有追踪的交易使用，下一轮再查询
+		return tracker.checkDropped(tx)
+	}
+	// nonce 还没有被使用，交易不在节点的交易池中时可能被丢弃了
+	onNode, err := tracker.requester.GetTransactionByHashContext(ctx, tx.Hash)
+	if err != nil {
+		return err
+	}
+	if onNode.Hash != "" {
+		if tx.MissingTime != 0 {
+			// 重新出现在交易池中，重新计算不在交易池中的时间
+			tx.MissingTime = 0
+			return tracker.update(tx, TxPending, "")
+		}
+		return nil
+	}
+	// 被之后广播的同 nonce 交易挤出了交易池
+	if last := siblings[len(siblings)-1]; last.Id > tx.Id {
+		return tracker.update(tx, TxReplaced, last.Hash)
+	}
+	return tracker.checkDropped(tx)
+}
+
+// 交易有了收据，达到确认数后根据执行结果标记为 mined 或者 failed
+func (tracker *TransactionTracker) confirm(tx *dao.TrackedTransaction, receipt *model.Receipt, latest uint64) error {
+	blockNumber, err := hexutil.DecodeUint64(receipt.BlockNumber)
+	if err != nil {
+		return err
+	}
+	if latest < blockNumber || latest-blockNumber < tracker.confirmations {
+		return nil // 还没有达到确认数
+	}
+	status := TxMined
+	if !receipt.Succeeded() {
+		status = TxFailed
+	}
+	tx.BlockNumber = new(big.Int).SetUint64(blockNumber).String()
+	if err := tracker.update(tx, status, ""); err != nil {
+		return err
+	}
+	return tracker.replaceSiblings(tx)
+}
+
+// 交易不在节点中，第一次发现时记录时间，超过 dropTimeout 后标记为 dropped
+func (tracker *TransactionTracker) checkDropped(tx *dao.TrackedTransaction) error {
+	if tx.MissingTime == 0 {
+		tx.MissingTime = time.Now().Unix()
+		return tracker.update(tx, TxPending, "")
+	}
+	if time.Since(time.Unix(tx.MissingTime, 0)) >= tracker.dropTimeout {
+		return tracker.update(tx, TxDropped, "")
+	}
+	return nil
+}
+
+// 交易达到最终状态后，同一个 nonce 的其它 pending 交易都被它替换
+func (tracker *TransactionTracker) replaceSiblings(tx *dao.TrackedTransaction) error {
+	siblings, err := tracker.store.ByNonce(tx.FromAddress, tx.Nonce)
+	if err != nil {
+		return err
+	}
+	for _, sibling := range siblings {
+		if sibling.Hash == tx.Hash || sibling.Status != TxPending {
+			continue
+		}
+		if err := tracker.update(sibling, TxReplaced, tx.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tracker *TransactionTracker) update(tx *dao.TrackedTransaction, status, replacedBy string) error {
+	tx.Status = status
+	tx.ReplacedBy = replacedBy
+	tx.UpdateTime = time.Now().Unix()
+	return tracker.store.Update(tx)
+}
+
+// SpeedUp 使用同一个 nonce、提高后的燃料费重新广播交易，返回新交易的哈希值
+func (tracker *TransactionTracker) SpeedUp(hash string) (string, error) {
+	ctx, cancel := tracker.requester.defaultContext()
+	defer cancel()
+	return tracker.SpeedUpContext(ctx, hash)
+}
+
+// SpeedUpContext 使用同一个 nonce、提高后的燃料费重新广播交易，返回新交易的哈希值
+func (tracker *TransactionTracker) SpeedUpContext(ctx context.Context, hash string) (string, error) {
+	return tracker.replace(ctx, hash, func(tx *dao.TrackedTransaction, old *types.Transaction) (*common.Address, *big.Int, uint64, []byte) {
+		return old.To(), old.Value(), old.Gas(), old.Data()
+	})
+}
+
+// Cancel 使用同一个 nonce、提高后的燃料费广播一笔发给自己的零值转账，返回取消交易的哈希值
+func (tracker *TransactionTracker) Cancel(hash string) (string, error) {
+	ctx, cancel := tracker.requester.defaultContext()
+	defer cancel()
+	return tracker.CancelContext(ctx, hash)
+}
+
+// CancelContext 使用同一个 nonce、提高后的燃料费广播一笔发给自己的零值转账，返回取消交易的哈希值
+func (tracker *TransactionTracker) CancelContext(ctx context.Context, hash string) (string, error) {
+	return tracker.replace(ctx, hash, func(tx *dao.TrackedTransaction, old *types.Transaction) (*common.Address, *big.Int, uint64, []byte) {
+		self := common.HexToAddress(tx.FromAddress)
+		return &self, new(big.Int), cancelGasLimit, nil
+	})
+}
+
+// 用同一个 nonce 构建替换交易并广播，燃料费取原交易提高 bumpPercent 后和当前建议值中较大的一个
+// build 返回替换交易的接收地址、数值、gasLimit 和 data
+func (tracker *TransactionTracker) replace(ctx context.Context, hash string, build func(tx *dao.TrackedTransaction, old *types.Transaction) (*common.Address, *big.Int, uint64, []byte)) (string, error) {
+	tx, err := tracker.Get(hash)
+	if err != nil {
+		return "", err
+	}
+	if tx.Status != TxPending {
+		return "", fmt.Errorf("%w: %s", ErrTxNotPending, tx.Status)
+	}
+	old := new(types.Transaction)
+	if err := old.UnmarshalBinary(common.FromHex(tx.RawTx)); err != nil {
+		return "", fmt.Errorf("解析原交易失败 %s", err.Error())
+	}
+	to, value, gasLimit, data := build(tx, old)
+	var replacement *types.Transaction
+	if old.Type() == types.DynamicFeeTxType {
+		suggest, err := tracker.requester.dynamicFee(ctx, FeeOption{})
+		if err != nil {
+			return "", err
+		}
+		replacement = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   old.ChainId(),
+			Nonce:     old.Nonce(),
+			GasTipCap: maxBig(tracker.bump(old.GasTipCap()), suggest.MaxPriorityFeePerGas),
+			GasFeeCap: maxBig(tracker.bump(old.GasFeeCap()), suggest.MaxFeePerGas),
+			Gas:       gasLimit,
+			To:        to,
+			Value:     value,
+			Data:      data,
+		})
+	} else {
+		gasPrice, err := tracker.requester.GetGasPriceContext(ctx)
+		if err != nil {
+			return "", err
+		}
+		replacement = types.NewTx(&types.LegacyTx{
+			Nonce:    old.Nonce(),
+			GasPrice: maxBig(tracker.bump(old.GasPrice()), gasPrice),
+			Gas:      gasLimit,
+			To:       to,
+			Value:    value,
+			Data:     data,
+		})
+	}
+	// 广播成功后替换交易也会被追踪，原交易在之后的轮询中标记为 replaced
+	return tracker.requester.signAndSend(ctx, tx.FromAddress, replacement)
+}
+
+// 燃料费提高 bumpPercent，并向上取整
+func (tracker *TransactionTracker) bump(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+tracker.bumpPercent))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
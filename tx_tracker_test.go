@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"eth-relay/dao"
+	"eth-relay/model"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 检查追踪交易的状态
+func checkTrackedStatus(t *testing.T, tracker *TransactionTracker, hash, status, replacedBy string) {
+	t.Helper()
+	tx, err := tracker.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != status || tx.ReplacedBy != replacedBy {
+		t.Fatalf("交易 %s 的状态错误 %s %s", hash, tx.Status, tx.ReplacedBy)
+	}
+}
+
+// 单元测试：追踪交易直到达到确认数，加速和取消的交易替换原交易
+func Test_TransactionTracker(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{
+		number:   100,
+		chainId:  1,
+		price:    10,
+		nonces:   map[string]uint64{address: 0},
+		mined:    map[string]uint64{},
+		pool:     map[string]bool{},
+		receipts: map[string]*model.Receipt{},
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	tracker := NewTransactionTracker(requester, NewMemTxStore())
+	tracker.SetConfirmations(2)
+	to := "0x3333333333333333333333333333333333333333"
+
+	first, err := requester.SendETHTransaction(address, to, "0.1", 21000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := requester.SendETHTransaction(address, to, "0.1", 21000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, first, TxPending, "")
+
+	// 加速：同一个 nonce，燃料费提高 12% 并向上取整
+	speedUp, err := tracker.SpeedUp(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.Nonce() != 0 || service.lastTx.GasPrice().Int64() != 12 {
+		t.Fatalf("加速交易错误 %d %v", service.lastTx.Nonce(), service.lastTx.GasPrice())
+	}
+	// 加速交易在 99 号区块被打包，原交易被挤出交易池
+	delete(service.pool, first)
+	service.receipts[speedUp] = &model.Receipt{TransactionHash: speedUp, BlockNumber: "0x63", Status: "0x1"}
+	service.mined[address] = 1
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, first, TxReplaced, speedUp)
+	checkTrackedStatus(t, tracker, speedUp, TxPending, "") // 还没有达到确认数
+	service.number = 101
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, speedUp, TxMined, "")
+	if _, err := tracker.Cancel(speedUp); !errors.Is(err, ErrTxNotPending) {
+		t.Fatalf("已经打包的交易不能取消 %v", err)
+	}
+
+	// 取消：同一个 nonce 发给自己的零值转账
+	cancelHash, err := tracker.Cancel(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.lastTx.Nonce() != 1 || service.lastTx.Value().Sign() != 0 ||
+		!strings.EqualFold(service.lastTx.To().Hex(), address) || service.lastTx.Gas() != 21000 {
+		t.Fatalf("取消交易错误 %+v", service.lastTx)
+	}
+	delete(service.pool, second)
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, second, TxReplaced, cancelHash)
+
+	// 取消交易也不在交易池中，超时后标记为 dropped
+	delete(service.pool, cancelHash)
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, cancelHash, TxPending, "")
+	tracker.SetDropTimeout(0)
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, cancelHash, TxDropped, "")
+	if _, err := tracker.Get("0x01"); !errors.Is(err, ErrTxNotTracked) {
+		t.Fatalf("应该返回 ErrTxNotTracked %v", err)
+	}
+
+	// Start 之后定时轮询，Stop 后退出
+	tracker.SetInterval(10 * time.Millisecond)
+	tracker.Start()
+	tracker.Stop()
+}
+
+// 单元测试：查询收据之后交易马上被打包，nonce 已经被使用时不能把交易标记为 replaced
+func Test_TransactionTrackerMinedBetweenQueries(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{
+		number:   100,
+		chainId:  1,
+		nonces:   map[string]uint64{address: 0},
+		mined:    map[string]uint64{},
+		pool:     map[string]bool{},
+		receipts: map[string]*model.Receipt{},
+	}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	tracker := NewTransactionTracker(requester, NewMemTxStore())
+	tracker.SetConfirmations(0)
+	hash, err := requester.SendETHTransaction(address, "0x3333333333333333333333333333333333333333", "0.1", 21000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nonce 被使用但是还查不到任何收据，保持 pending 等待下一轮
+	service.mined[address] = 1
+	delete(service.pool, hash)
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, hash, TxPending, "")
+
+	// 收据在查询收据和查询 nonce 之间出现
+	service.onNonce = func() {
+		service.receipts[hash] = &model.Receipt{TransactionHash: hash, BlockNumber: "0x64", Status: "0x1"}
+	}
+	if err := tracker.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkTrackedStatus(t, tracker, hash, TxMined, "")
+}
+
+// 保存和查询都失败的交易存储
+type failingTxStore struct {
+	*MemTxStore
+}
+
+var errTxStoreDown = errors.New("tx store is down")
+
+func (s *failingTxStore) Insert(tx *dao.TrackedTransaction) error {
+	return errTxStoreDown
+}
+
+func (s *failingTxStore) Pending() ([]*dao.TrackedTransaction, error) {
+	return nil, errTxStoreDown
+}
+
+// 单元测试：保存追踪交易失败时同时返回交易哈希值和错误，后台轮询的错误交给回调
+func Test_TransactionTrackerErrors(t *testing.T) {
+	address := unlockTestAccount(t)
+	service := &testEthService{number: 100, chainId: 1, nonces: map[string]uint64{address: 0}}
+	requester := newTestRequester(t, startTestIPCNode(t, service))
+	tracker := NewTransactionTracker(requester, &failingTxStore{MemTxStore: NewMemTxStore()})
+	to := "0x3333333333333333333333333333333333333333"
+
+	hash, err := requester.SendETHTransaction(address, to, "0.1", 21000, 10)
+	if hash == "" || !errors.Is(err, ErrTxTrackFailed) {
+		t.Fatalf("应该同时返回交易哈希值和 ErrTxTrackFailed %s %v", hash, err)
+	}
+	// 交易已经广播，nonce 不能归还
+	if _, err := requester.SendETHTransaction(address, to, "0.1", 21000, 10); !errors.Is(err, ErrTxTrackFailed) {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(service.sent) != "[0 1]" {
+		t.Fatalf("广播的 nonce 错误 %v", service.sent)
+	}
+
+	errs := make(chan error, 1)
+	tracker.SetInterval(10 * time.Millisecond)
+	tracker.SetErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	tracker.Start()
+	defer tracker.Stop()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errTxStoreDown) {
+			t.Fatalf("轮询错误错误 %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("轮询错误没有交给回调")
+	}
+}